@@ -0,0 +1,134 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+	"reflect"
+	"sync/atomic"
+)
+
+// 本包实现的负载均衡，只有这些负载均衡能读取AddressMeta
+var metaBalancers = map[string]bool{
+	WeightedRoundRobin: true,
+	ConsistentHash:     true,
+	LeastRequest:       true,
+	Locality:           true,
+}
+
+// 创建本包的负载均衡，在base balancer之上叠加会话保持、分片路由和路由规则
+// 权重、层级、tags等实例信息会随consul变化，base balancer以resolver.Address作为subConn的key，
+// 因此交给base balancer前去掉Metadata，另存在以Addr为key的map中，构建picker时再附加回去，
+// 实例信息变化时只重建picker，不会断开重连
+func newBalancerBuilder(name string, pb base.PickerBuilder) balancer.Builder {
+	return &metaBalancerBuilder{name: name, pb: layered(pb)}
+}
+
+type metaBalancerBuilder struct {
+	name string
+	pb   base.PickerBuilder
+}
+
+func (bb *metaBalancerBuilder) Name() string {
+	return bb.name
+}
+
+func (bb *metaBalancerBuilder) Build(cc balancer.ClientConn, opts balancer.BuildOptions) balancer.Balancer {
+	b := &metaBalancer{cc: cc, pb: bb.pb, metas: make(map[string]AddressMeta)}
+	b.picker.Store(pickerHolder{base.NewErrPicker(balancer.ErrNoSubConnAvailable)})
+	builder := base.NewBalancerBuilderWithConfig(bb.name, &metaPickerBuilder{b: b}, base.Config{HealthCheck: true})
+	b.Balancer = builder.Build(&metaClientConn{ClientConn: cc, b: b}, opts)
+	return b
+}
+
+// gRPC串行调用balancer的方法，除picker外的字段不需要加锁
+type metaBalancer struct {
+	balancer.Balancer
+	cc     balancer.ClientConn
+	pb     base.PickerBuilder
+	metas  map[string]AddressMeta // 按Addr保存的实例信息
+	ready  map[resolver.Address]balancer.SubConn
+	state  connectivity.State
+	picker atomic.Value // pickerHolder，Pick时读取
+}
+
+type pickerHolder struct {
+	balancer.Picker
+}
+
+func (b *metaBalancer) HandleResolvedAddrs(addrs []resolver.Address, err error) {
+	if err != nil {
+		b.Balancer.HandleResolvedAddrs(addrs, err)
+		return
+	}
+	metas := make(map[string]AddressMeta, len(addrs))
+	stripped := make([]resolver.Address, 0, len(addrs))
+	for _, addr := range addrs {
+		metas[addr.Addr] = addressMeta(addr)
+		addr.Metadata = nil
+		stripped = append(stripped, addr)
+	}
+	changed := !reflect.DeepEqual(metas, b.metas)
+	b.metas = metas
+	b.Balancer.HandleResolvedAddrs(stripped, nil)
+	if changed && b.ready != nil && b.state != connectivity.TransientFailure {
+		// base balancer在subConn关闭后才更新可用列表，先去掉本次已移除的实例
+		ready := make(map[resolver.Address]balancer.SubConn, len(b.ready))
+		for addr, sc := range b.ready {
+			if _, ok := metas[addr.Addr]; ok {
+				ready[addr] = sc
+			}
+		}
+		b.build(ready)
+		b.cc.UpdateBalancerState(b.state, &metaPicker{b: b})
+	}
+}
+
+// 附加上实例信息后构建picker
+func (b *metaBalancer) build(ready map[resolver.Address]balancer.SubConn) {
+	b.ready = ready
+	tierSizes := make(map[int]int)
+	for _, meta := range b.metas {
+		tierSizes[meta.Tier]++
+	}
+	withMeta := make(map[resolver.Address]balancer.SubConn, len(ready))
+	for addr, sc := range ready {
+		if meta, ok := b.metas[addr.Addr]; ok {
+			meta.TierSize = tierSizes[meta.Tier]
+			addr.Metadata = meta
+		}
+		withMeta[addr] = sc
+	}
+	b.picker.Store(pickerHolder{b.pb.Build(withMeta)})
+}
+
+type metaPickerBuilder struct {
+	b *metaBalancer
+}
+
+func (pb *metaPickerBuilder) Build(readySCs map[resolver.Address]balancer.SubConn) balancer.Picker {
+	pb.b.build(readySCs)
+	return &metaPicker{b: pb.b}
+}
+
+// 始终使用最近一次构建的picker，实例信息变化后重建的picker也能生效
+type metaPicker struct {
+	b *metaBalancer
+}
+
+func (p *metaPicker) Pick(ctx context.Context, opts balancer.PickOptions) (balancer.SubConn, func(balancer.DoneInfo), error) {
+	return p.b.picker.Load().(pickerHolder).Pick(ctx, opts)
+}
+
+// 记录base balancer上报的状态
+type metaClientConn struct {
+	balancer.ClientConn
+	b *metaBalancer
+}
+
+func (cc *metaClientConn) UpdateBalancerState(s connectivity.State, p balancer.Picker) {
+	cc.b.state = s
+	cc.ClientConn.UpdateBalancerState(s, p)
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/resolver"
+	"testing"
+)
+
+type fakeClientConn struct {
+	balancer.ClientConn
+	subConns map[balancer.SubConn]string
+	removed  int
+	picker   balancer.Picker
+}
+
+func (cc *fakeClientConn) NewSubConn(addrs []resolver.Address, opts balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	sc := &fakeSubConn{addr: addrs[0].Addr}
+	cc.subConns[sc] = addrs[0].Addr
+	return sc, nil
+}
+
+func (cc *fakeClientConn) RemoveSubConn(sc balancer.SubConn) {
+	cc.removed++
+}
+
+func (cc *fakeClientConn) UpdateBalancerState(s connectivity.State, p balancer.Picker) {
+	cc.picker = p
+}
+
+// 权重变化时只重建picker，不重建subConn
+func TestMetaBalancerKeepsSubConns(t *testing.T) {
+	cc := &fakeClientConn{subConns: make(map[balancer.SubConn]string)}
+	b := newBalancerBuilder(WeightedRoundRobin, &wrrPickerBuilder{}).Build(cc, balancer.BuildOptions{})
+	b.HandleResolvedAddrs([]resolver.Address{
+		{Addr: "a", Metadata: AddressMeta{Weight: 1}},
+		{Addr: "b", Metadata: AddressMeta{Weight: 1}},
+	}, nil)
+	for sc := range cc.subConns {
+		b.HandleSubConnStateChange(sc, connectivity.Ready)
+	}
+
+	b.HandleResolvedAddrs([]resolver.Address{
+		{Addr: "a", Metadata: AddressMeta{Weight: 3, Tier: 1, Tags: "canary"}},
+		{Addr: "b", Metadata: AddressMeta{Weight: 1}},
+	}, nil)
+	if len(cc.subConns) != 2 || cc.removed != 0 {
+		t.Fatalf("subConns= %v, removed= %v, want no reconnect", len(cc.subConns), cc.removed)
+	}
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		sc, _, err := cc.picker.Pick(context.Background(), balancer.PickOptions{})
+		if err != nil {
+			t.Fatalf("pick failed, err= %v", err)
+		}
+		counts[sc.(*fakeSubConn).addr]++
+	}
+	if counts["a"] != 6 || counts["b"] != 2 {
+		t.Fatalf("counts= %v, want new weights applied", counts)
+	}
+}
+
+// 实例信息变化的同时移除了实例，重建的picker不再选择被移除的实例
+func TestMetaBalancerDropsRemoved(t *testing.T) {
+	cc := &fakeClientConn{subConns: make(map[balancer.SubConn]string)}
+	b := newBalancerBuilder(WeightedRoundRobin, &wrrPickerBuilder{}).Build(cc, balancer.BuildOptions{})
+	b.HandleResolvedAddrs([]resolver.Address{
+		{Addr: "a", Metadata: AddressMeta{Weight: 1}},
+		{Addr: "b", Metadata: AddressMeta{Weight: 1}},
+	}, nil)
+	for sc := range cc.subConns {
+		b.HandleSubConnStateChange(sc, connectivity.Ready)
+	}
+
+	b.HandleResolvedAddrs([]resolver.Address{{Addr: "a", Metadata: AddressMeta{Weight: 2}}}, nil)
+	for i := 0; i < 4; i++ {
+		sc, _, err := cc.picker.Pick(context.Background(), balancer.PickOptions{})
+		if err != nil {
+			t.Fatalf("pick failed, err= %v", err)
+		}
+		if addr := sc.(*fakeSubConn).addr; addr != "a" {
+			t.Fatalf("picked %v, want removed instance dropped", addr)
+		}
+	}
+}
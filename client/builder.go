@@ -1,7 +1,6 @@
 package client
 
 import (
-	consul "github.com/hashicorp/consul/api"
 	"google.golang.org/grpc/resolver"
 )
 
@@ -16,16 +15,21 @@ func NewBuilder(scheme string) resolver.Builder {
 }
 func (b *ConsulBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOption) (resolver.Resolver, error) {
 
-	client, err := consul.NewClient(consul.DefaultConfig())
+	client, err := newConsulClient()
 	if err != nil {
 		return nil, err
 	}
 	r := &ConsulResolver{
-		target: target,
-		cc:     cc,
-		client: client,
-		addr:   make(chan []resolver.Address, 1),
-		done:   make(chan struct{}, 1),
+		target:  target,
+		cc:      cc,
+		client:  client,
+		addr:    make(chan []resolver.Address, 1),
+		done:    make(chan struct{}, 1),
+		options: *defaultOption(),
+	}
+	if GClient != nil {
+		r.options = *GClient.options
+		r.outlier = GClient.outlierDetector(target.Endpoint)
 	}
 	go r.updater()
 	go r.watch()
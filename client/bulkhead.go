@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"github.com/armon/go-metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+// 下游服务隔离舱已满时的错误码，与CodeLocalThrottled一样只会在本地产生
+const CodeBulkheadFull codes.Code = 101
+
+// IsBulkheadFull 判断错误是否为隔离舱在本地拒绝
+func IsBulkheadFull(err error) bool {
+	return status.Code(err) == CodeBulkheadFull
+}
+
+// BulkheadConfig 单个下游服务的并发隔离配置
+type BulkheadConfig struct {
+	MaxConcurrent int           // 最大并发调用数
+	MaxQueue      int           // 排队等待的最大请求数，为0时不排队直接拒绝
+	MaxWait       time.Duration // 最长排队时间，为0时只受请求deadline限制
+}
+
+func (c BulkheadConfig) withDefaults() BulkheadConfig {
+	if c.MaxConcurrent <= 0 {
+		c.MaxConcurrent = 100
+	}
+	return c
+}
+
+// 限制单个下游服务的并发调用数，慢服务只会占满自己的隔离舱
+type bulkhead struct {
+	sync.Mutex
+	service string
+	conf    BulkheadConfig
+	slots   chan struct{}
+	queued  int
+}
+
+func newBulkhead(service string, conf BulkheadConfig) *bulkhead {
+	conf = conf.withDefaults()
+	return &bulkhead{
+		service: service,
+		conf:    conf,
+		slots:   make(chan struct{}, conf.MaxConcurrent),
+	}
+}
+
+func (b *bulkhead) reject(reason string, format string, args ...interface{}) error {
+	metrics.IncrCounter([]string{"client", "bulkhead", b.service, "rejected", reason}, 1)
+	return status.Errorf(CodeBulkheadFull, format, args...)
+}
+
+// 获取调用名额，名额已满时在队列中等待，队列已满、等待超时或请求deadline到期时返回错误
+func (b *bulkhead) acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	b.Lock()
+	if b.queued >= b.conf.MaxQueue {
+		b.Unlock()
+		return b.reject("queue_full", "bulkhead of %v is full, max concurrent= %v, max queue= %v", b.service, b.conf.MaxConcurrent, b.conf.MaxQueue)
+	}
+	b.queued++
+	b.Unlock()
+	defer func() {
+		b.Lock()
+		b.queued--
+		b.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if b.conf.MaxWait > 0 {
+		timer := time.NewTimer(b.conf.MaxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		return b.reject("wait_timeout", "bulkhead of %v wait timeout after %v", b.service, b.conf.MaxWait)
+	case <-ctx.Done():
+		return b.reject("deadline", "bulkhead of %v wait canceled, err= %v", b.service, ctx.Err())
+	}
+}
+
+func (b *bulkhead) release() {
+	<-b.slots
+	b.reportInflight()
+}
+
+func (b *bulkhead) reportInflight() {
+	metrics.SetGauge([]string{"client", "bulkhead", b.service, "inflight"}, float32(len(b.slots)))
+}
+
+func (b *bulkhead) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if err := b.acquire(ctx); err != nil {
+		return err
+	}
+	b.reportInflight()
+	defer b.release()
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBulkheadQueue(t *testing.T) {
+	b := newBulkhead("test", BulkheadConfig{MaxConcurrent: 1, MaxQueue: 1, MaxWait: 50 * time.Millisecond})
+	ctx := context.Background()
+	if err := b.acquire(ctx); err != nil {
+		t.Fatalf("first acquire failed, err= %v", err)
+	}
+
+	// 排队的请求在名额释放后获得名额
+	acquired := make(chan error, 1)
+	go func() {
+		acquired <- b.acquire(ctx)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// 队列已满时直接拒绝
+	if err := b.acquire(ctx); !IsBulkheadFull(err) {
+		t.Fatalf("acquire with full queue, err= %v, want bulkhead full", err)
+	}
+	b.release()
+	if err := <-acquired; err != nil {
+		t.Fatalf("queued acquire failed, err= %v", err)
+	}
+
+	// 等待超时
+	if err := b.acquire(ctx); !IsBulkheadFull(err) {
+		t.Fatalf("acquire after max wait, err= %v, want bulkhead full", err)
+	}
+
+	// 请求deadline先于最长排队时间到期
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if err := b.acquire(ctx); !IsBulkheadFull(err) {
+		t.Fatalf("acquire after deadline, err= %v, want bulkhead full", err)
+	}
+}
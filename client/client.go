@@ -3,18 +3,29 @@ package client
 import (
 	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/roundrobin"
 	"google.golang.org/grpc/resolver"
 	"sync"
 	"time"
 )
 
+const scheme = "test"
+
 func init() {
-	resolver.Register(NewBuilder("test")) // consul lb
+	resolver.Register(NewBuilder(scheme)) // consul lb
+	balancer.Register(newWeightedRoundRobinBuilder())
+	balancer.Register(newConsistentHashBuilder())
+	balancer.Register(newLeastRequestBuilder())
+	balancer.Register(newLocalityBuilder())
 }
 
 type Client struct {
 	sync.RWMutex
 	connPool map[string]*grpc.ClientConn
+	outliers map[string]*outlierDetector
+	router   *Router
+	sessions *sessionTable
 	options  *Option
 }
 
@@ -22,16 +33,29 @@ var GClient *Client
 
 func Init(opts ...Options) {
 	var client Client
-	client.options = &Option{
-		watchInterval: 20 * time.Second,
-	}
+	client.options = defaultOption()
 	for _, opt := range opts {
 		opt(client.options)
 	}
 	client.connPool = make(map[string]*grpc.ClientConn)
+	client.outliers = make(map[string]*outlierDetector)
+	if client.options.routing != nil {
+		client.router = newRouter(client.options.caller)
+		client.router.start(*client.options.routing, client.options.consulConfig(), nil)
+	}
+	if client.options.session != nil {
+		client.sessions = newSessionTable(*client.options.session)
+	}
 	GClient = &client
 }
 
+func defaultOption() *Option {
+	return &Option{
+		watchInterval: 20 * time.Second,
+		balancer:      roundrobin.Name,
+	}
+}
+
 func GetConn(serviceName string) (*grpc.ClientConn, error) {
 	GClient.RLock()
 	if cli, ok := GClient.connPool[serviceName]; ok {
@@ -41,11 +65,11 @@ func GetConn(serviceName string) (*grpc.ClientConn, error) {
 	GClient.RUnlock()
 
 	// 通过consul服务发现
-	service, err := discovery(serviceName)
-	if err != nil {
+	if _, err := discovery(serviceName); err != nil {
 		return nil, err
 	}
-	conn, err := grpc.Dial(fmt.Sprintf("%s:%d", service.Address, service.Port), grpc.WithInsecure())
+	// 地址由ConsulResolver解析并持续更新
+	conn, err := grpc.Dial(fmt.Sprintf("%s:///%s", scheme, serviceName), GClient.dialOptions(serviceName)...)
 	if err != nil {
 		return nil, err
 	}
@@ -56,6 +80,52 @@ func GetConn(serviceName string) (*grpc.ClientConn, error) {
 	return conn, nil
 }
 
+func (c *Client) dialOptions(serviceName string) []grpc.DialOption {
+	dialOpts := []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithBalancerName(c.options.balancerName()),
+	}
+	var interceptors []grpc.UnaryClientInterceptor
+	if !c.options.readsShard() {
+		interceptors = append(interceptors, shardUnsupportedInterceptor)
+	}
+	// 隔离舱在自适应限流外层，隔离舱已满的请求不计入限流统计
+	if conf, ok := c.options.bulkheadConfig(serviceName); ok {
+		interceptors = append(interceptors, newBulkhead(serviceName, conf).unaryInterceptor)
+	}
+	if conf, ok := c.options.throttleConfig(serviceName); ok {
+		interceptors = append(interceptors, newThrottler(serviceName, conf).unaryInterceptor)
+	}
+	if conf, ok := c.options.mirrors[serviceName]; ok {
+		interceptors = append(interceptors, newMirror(serviceName, conf).unaryInterceptor)
+	}
+	if c.options.caller != "" {
+		interceptors = append(interceptors, callerInterceptor(c.options.caller))
+	}
+	if d := c.outlierDetector(serviceName); d != nil {
+		interceptors = append(interceptors, d.unaryInterceptor)
+	}
+	if len(interceptors) > 0 {
+		dialOpts = append(dialOpts, grpc.WithUnaryInterceptor(chainUnaryInterceptors(interceptors...)))
+	}
+	return dialOpts
+}
+
+// 获取服务对应的离群检测器，未开启离群检测时返回nil
+func (c *Client) outlierDetector(serviceName string) *outlierDetector {
+	if c.options.outlier == nil {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	d, ok := c.outliers[serviceName]
+	if !ok {
+		d = newOutlierDetector(serviceName, *c.options.outlier)
+		c.outliers[serviceName] = d
+	}
+	return d
+}
+
 func Close(service string) error {
 	if conn, ok := GClient.connPool[service]; ok {
 		return conn.Close()
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/serf/coordinate"
+	"time"
+)
+
+// CoordinateConfig 基于consul网络坐标的就近路由配置
+type CoordinateConfig struct {
+	TierWidth time.Duration // 与最近实例的RTT差值每增加TierWidth，层级加1，默认5ms
+	LocalityConfig
+}
+
+func (c CoordinateConfig) withDefaults() CoordinateConfig {
+	if c.TierWidth <= 0 {
+		c.TierWidth = 5 * time.Millisecond
+	}
+	return c
+}
+
+// 估算本节点到各节点的RTT，无法估算的节点不在返回结果中
+func estimateRTT(client *api.Client) (map[string]time.Duration, error) {
+	localNode, err := client.Agent().NodeName()
+	if err != nil {
+		return nil, err
+	}
+	entries, _, err := client.Coordinate().Nodes(nil)
+	if err != nil {
+		return nil, err
+	}
+	var local *api.CoordinateEntry
+	for _, entry := range entries {
+		if entry.Node == localNode {
+			local = entry
+			break
+		}
+	}
+	rtts := make(map[string]time.Duration, len(entries))
+	if local == nil || local.Coord == nil {
+		return rtts, nil
+	}
+	for _, entry := range entries {
+		// 不同网络分段的坐标不可比较
+		if entry.Segment != local.Segment || !compatible(local.Coord, entry.Coord) {
+			continue
+		}
+		rtts[entry.Node] = local.Coord.DistanceTo(entry.Coord)
+	}
+	return rtts, nil
+}
+
+func compatible(a, b *coordinate.Coordinate) bool {
+	return a != nil && b != nil && a.IsCompatibleWith(b)
+}
+
+// 按RTT划分层级，无法估算RTT的节点放在最远的层级
+func rttTiers(nodes []string, rtts map[string]time.Duration, width time.Duration) []int {
+	var minRTT time.Duration = -1
+	maxTier := 0
+	for _, node := range nodes {
+		if rtt, ok := rtts[node]; ok && (minRTT < 0 || rtt < minRTT) {
+			minRTT = rtt
+		}
+	}
+	tiers := make([]int, len(nodes))
+	for i, node := range nodes {
+		rtt, ok := rtts[node]
+		if !ok {
+			tiers[i] = -1
+			continue
+		}
+		tiers[i] = int((rtt - minRTT) / width)
+		if tiers[i] > maxTier {
+			maxTier = tiers[i]
+		}
+	}
+	for i := range tiers {
+		if tiers[i] < 0 {
+			tiers[i] = maxTier + 1
+		}
+	}
+	return tiers
+}
@@ -1,24 +1,43 @@
 package client
 
 import (
-	"code.byted.org/golf/consul"
 	"errors"
 	"github.com/Carey6918/PikaRPC/helper"
+	"github.com/hashicorp/consul/api"
 )
 
-const consulPort = "8500"
-
-func discovery(serviceName string) (*consul.AgentService, error) {
-	config := consul.DefaultConfig()
-	config.Address = helper.GetLocalAddress(consulPort)
-	client, err := consul.NewClient(config)
+func discovery(serviceName string) (*api.AgentService, error) {
+	client, err := newConsulClient()
 	if err != nil {
 		return nil, err
 	}
 	services, err := client.Agent().Services()
+	if err != nil {
+		return nil, err
+	}
 	service, ok := services[serviceName]
 	if !ok {
 		return nil, errors.New("cannot find service")
 	}
 	return service, nil
 }
+
+// 客户端使用的consul配置，未通过WithConsul设置时使用helper.DefaultConsulConfig
+func (o *Option) consulConfig() helper.ConsulConfig {
+	if o.consul != nil {
+		return *o.consul
+	}
+	return helper.DefaultConsulConfig()
+}
+
+func consulConfig() helper.ConsulConfig {
+	if GClient != nil {
+		return GClient.options.consulConfig()
+	}
+	return helper.DefaultConsulConfig()
+}
+
+// 按consul配置创建客户端，用于服务发现、读取KV等
+func newConsulClient() (*api.Client, error) {
+	return consulConfig().NewClient()
+}
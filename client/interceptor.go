@@ -0,0 +1,35 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// 携带调用方身份的metadata key
+const CallerMetadataKey = "pika-caller"
+
+// 将多个客户端拦截器串联为一个，按传入顺序由外到内执行
+func chainUnaryInterceptors(interceptors ...grpc.UnaryClientInterceptor) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		chained := invoker
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			chained = bindInvoker(interceptors[i], chained)
+		}
+		return chained(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func bindInvoker(interceptor grpc.UnaryClientInterceptor, next grpc.UnaryInvoker) grpc.UnaryInvoker {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return interceptor(ctx, method, req, reply, cc, next, opts...)
+	}
+}
+
+// 在outgoing metadata中附带调用方身份
+func callerInterceptor(caller string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, CallerMetadataKey, caller)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+)
+
+// 在负载均衡策略之上依次叠加会话保持、分片路由和路由规则，各层未开启时不生效
+func layered(pb base.PickerBuilder) base.PickerBuilder {
+	return &layeredPickerBuilder{inner: pb}
+}
+
+type layeredPickerBuilder struct {
+	inner base.PickerBuilder
+}
+
+func (b *layeredPickerBuilder) Build(readySCs map[resolver.Address]balancer.SubConn) balancer.Picker {
+	all := b.inner.Build(readySCs)
+	picker := newRoutedPicker(b.inner, readySCs, all)
+	picker = newShardPicker(b.inner, readySCs, picker)
+	if GClient != nil && GClient.sessions != nil {
+		picker = GClient.sessions.picker(readySCs, picker, all)
+	}
+	return picker
+}
+
+// 能为指定实例记录负载的picker，会话保持不经过内层picker选择实例时，
+// 通过它把请求计入负载，返回请求结束时的回调
+type loadTracker interface {
+	track(sc balancer.SubConn) func(balancer.DoneInfo)
+}
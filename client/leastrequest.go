@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 最少请求负载均衡的名称，随机选两个实例，取未完成请求更少(或延迟更低)的一个
+const LeastRequest = "pika_least_request"
+
+// LeastRequestConfig 最少请求负载均衡配置
+type LeastRequestConfig struct {
+	EWMA  bool          // 是否结合延迟的指数加权平均打分，打分 = (未完成请求数+1) * 平均延迟
+	Decay time.Duration // 延迟平均值的衰减时间，默认10s
+}
+
+func (c LeastRequestConfig) withDefaults() LeastRequestConfig {
+	if c.Decay <= 0 {
+		c.Decay = 10 * time.Second
+	}
+	return c
+}
+
+func newLeastRequestBuilder() balancer.Builder {
+	return newBalancerBuilder(LeastRequest, &leastRequestPickerBuilder{})
+}
+
+type leastRequestPickerBuilder struct{}
+
+func (*leastRequestPickerBuilder) Build(readySCs map[resolver.Address]balancer.SubConn) balancer.Picker {
+	conf := LeastRequestConfig{}
+	if GClient != nil && GClient.options.leastRequest != nil {
+		conf = *GClient.options.leastRequest
+	}
+	p := &leastRequestPicker{
+		conf: conf.withDefaults(),
+		rand: rand.Intn,
+	}
+	for _, sc := range readySCs {
+		p.subConns = append(p.subConns, &subConnLoad{subConn: sc})
+	}
+	return p
+}
+
+type subConnLoad struct {
+	subConn     balancer.SubConn
+	outstanding int
+	latency     float64 // 延迟的指数加权平均，单位ns
+	updated     time.Time
+}
+
+// 调用方需持有锁，还没有延迟数据的实例按pending估算，避免新实例在收到第一个响应前被集中选中
+func (s *subConnLoad) score(ewma bool, pending float64) float64 {
+	if !ewma {
+		return float64(s.outstanding)
+	}
+	latency := s.latency
+	if s.updated.IsZero() {
+		latency = pending
+	}
+	return float64(s.outstanding+1) * latency
+}
+
+func (s *subConnLoad) observe(rtt time.Duration, now time.Time, decay time.Duration) {
+	if s.updated.IsZero() {
+		s.latency = float64(rtt)
+	} else {
+		w := math.Exp(-float64(now.Sub(s.updated)) / float64(decay))
+		s.latency = s.latency*w + float64(rtt)*(1-w)
+	}
+	s.updated = now
+}
+
+type leastRequestPicker struct {
+	sync.Mutex
+	conf       LeastRequestConfig
+	subConns   []*subConnLoad
+	rand       func(n int) int
+	latencySum float64 // 已有延迟数据的实例的延迟之和
+	observed   int     // 已有延迟数据的实例数
+}
+
+// 还没有延迟数据的实例使用已观测实例的平均延迟，都没有时为1，打分退化为未完成请求数+1
+func (p *leastRequestPicker) pendingLatency() float64 {
+	if p.observed == 0 {
+		return 1
+	}
+	return p.latencySum / float64(p.observed)
+}
+
+func (p *leastRequestPicker) Pick(ctx context.Context, opts balancer.PickOptions) (balancer.SubConn, func(balancer.DoneInfo), error) {
+	if len(p.subConns) == 0 {
+		return nil, nil, balancer.ErrNoSubConnAvailable
+	}
+	p.Lock()
+	defer p.Unlock()
+	picked := p.subConns[p.rand(len(p.subConns))]
+	if len(p.subConns) > 1 {
+		i := p.rand(len(p.subConns) - 1)
+		other := p.subConns[i]
+		if other == picked {
+			other = p.subConns[len(p.subConns)-1]
+		}
+		pending := p.pendingLatency()
+		if other.score(p.conf.EWMA, pending) < picked.score(p.conf.EWMA, pending) {
+			picked = other
+		}
+	}
+	return picked.subConn, p.start(picked), nil
+}
+
+// 记录实例的未完成请求，返回请求结束时的回调，调用方需持有锁
+func (p *leastRequestPicker) start(picked *subConnLoad) func(balancer.DoneInfo) {
+	picked.outstanding++
+	start := time.Now()
+	return func(balancer.DoneInfo) {
+		now := time.Now()
+		p.Lock()
+		picked.outstanding--
+		if picked.updated.IsZero() {
+			p.observed++
+		}
+		p.latencySum -= picked.latency
+		picked.observe(now.Sub(start), now, p.conf.Decay)
+		p.latencySum += picked.latency
+		p.Unlock()
+	}
+}
+
+func (p *leastRequestPicker) track(sc balancer.SubConn) func(balancer.DoneInfo) {
+	p.Lock()
+	defer p.Unlock()
+	for _, s := range p.subConns {
+		if s.subConn == sc {
+			return p.start(s)
+		}
+	}
+	return nil
+}
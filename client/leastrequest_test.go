@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+	"testing"
+	"time"
+)
+
+func TestLeastRequestPicksLessLoaded(t *testing.T) {
+	readySCs := map[resolver.Address]balancer.SubConn{
+		{Addr: "a"}: &fakeSubConn{addr: "a"},
+		{Addr: "b"}: &fakeSubConn{addr: "b"},
+	}
+	p := (&leastRequestPickerBuilder{}).Build(readySCs).(*leastRequestPicker)
+
+	var dones []func(balancer.DoneInfo)
+	counts := make(map[string]int)
+	for i := 0; i < 10; i++ {
+		sc, done, err := p.Pick(context.Background(), balancer.PickOptions{})
+		if err != nil {
+			t.Fatalf("pick failed, err= %v", err)
+		}
+		counts[sc.(*fakeSubConn).addr]++
+		dones = append(dones, done)
+	}
+	// 两个实例时每次都比较全部实例，未完成请求数应保持均衡
+	if counts["a"] != 5 || counts["b"] != 5 {
+		t.Fatalf("counts= %v, want a:5 b:5", counts)
+	}
+	for _, done := range dones {
+		done(balancer.DoneInfo{})
+	}
+	for _, s := range p.subConns {
+		if s.outstanding != 0 {
+			t.Fatalf("outstanding= %v, want 0", s.outstanding)
+		}
+	}
+}
+
+func TestLeastRequestEWMA(t *testing.T) {
+	now := time.Now()
+	fast := &subConnLoad{}
+	slow := &subConnLoad{}
+	fast.observe(time.Millisecond, now, time.Second)
+	slow.observe(100*time.Millisecond, now, time.Second)
+	fast.outstanding = 5
+	if fast.score(false, 0) <= slow.score(false, 0) {
+		t.Fatalf("without EWMA the instance with more outstanding requests should score worse")
+	}
+	if fast.score(true, 0) >= slow.score(true, 0) {
+		t.Fatalf("with EWMA the fast instance should score better")
+	}
+
+	// 新实例按平均延迟估算，未完成请求多时不会被选中
+	fresh := &subConnLoad{outstanding: 100}
+	pending := (fast.latency + slow.latency) / 2
+	if fresh.score(true, pending) <= slow.score(true, pending) {
+		t.Fatalf("fresh instance with many outstanding requests should score worse")
+	}
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"github.com/armon/go-metrics"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+	"sort"
+	"sync"
+)
+
+// 就近优先负载均衡的名称，优先访问AddressMeta.Tier最小的实例，故障或过载时才溢出到更远的实例
+const Locality = "pika_locality"
+
+// LocalityConfig 就近优先负载均衡配置
+type LocalityConfig struct {
+	MinReadyRatio  float64 // 当前层级可用实例占该层级实例总数的比例低于该值时，同时使用下一层级的实例，默认0.5
+	MaxOutstanding int     // 单实例未完成请求数上限，当前层级全部达到上限时溢出到下一层级，为0时不限制
+}
+
+func (c LocalityConfig) withDefaults() LocalityConfig {
+	if c.MinReadyRatio <= 0 {
+		c.MinReadyRatio = 0.5
+	}
+	return c
+}
+
+// 合并配置，未设置的字段取base中的值
+func (c LocalityConfig) merge(base LocalityConfig) LocalityConfig {
+	if c.MinReadyRatio <= 0 {
+		c.MinReadyRatio = base.MinReadyRatio
+	}
+	if c.MaxOutstanding <= 0 {
+		c.MaxOutstanding = base.MaxOutstanding
+	}
+	return c
+}
+
+func newLocalityBuilder() balancer.Builder {
+	return newBalancerBuilder(Locality, &localityPickerBuilder{})
+}
+
+type localityPickerBuilder struct{}
+
+func (*localityPickerBuilder) Build(readySCs map[resolver.Address]balancer.SubConn) balancer.Picker {
+	conf := LocalityConfig{}
+	if GClient != nil && GClient.options.locality != nil {
+		conf = *GClient.options.locality
+	}
+	return newLocalityPicker(readySCs, conf.withDefaults())
+}
+
+// 按层级从近到远排列的实例
+type localityPicker struct {
+	sync.Mutex
+	conf     LocalityConfig
+	subConns []*subConnLoad
+	addrs    []resolver.Address // 与subConns一一对应
+	tiers    []int              // 每个实例所在的层级，与subConns一一对应
+	sizes    []int              // 每个实例所在层级的实例总数(包括未就绪的)，与subConns一一对应
+	zone     string             // 开启可用区路由时客户端所在的可用区
+	next     int
+}
+
+func newLocalityPicker(readySCs map[resolver.Address]balancer.SubConn, conf LocalityConfig) *localityPicker {
+	addrs := make([]resolver.Address, 0, len(readySCs))
+	for addr := range readySCs {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return addressMeta(addrs[i]).Tier < addressMeta(addrs[j]).Tier
+	})
+	p := &localityPicker{conf: conf}
+	for _, addr := range addrs {
+		p.subConns = append(p.subConns, &subConnLoad{subConn: readySCs[addr]})
+		p.addrs = append(p.addrs, addr)
+		p.tiers = append(p.tiers, addressMeta(addr).Tier)
+		p.sizes = append(p.sizes, addressMeta(addr).TierSize)
+	}
+	if GClient != nil && GClient.options.zone != nil {
+		p.zone = GClient.options.zone.Zone
+	}
+	return p
+}
+
+// 返回参与本次选择的实例数：从最近的层级开始，直到可用实例占比不低于MinReadyRatio
+func (p *localityPicker) candidates(from int) int {
+	end := from
+	ready, total := 0, 0
+	for end < len(p.subConns) {
+		start, tier := end, p.tiers[end]
+		for end < len(p.subConns) && p.tiers[end] == tier {
+			end++
+		}
+		ready += end - start
+		if size := p.sizes[start]; size > end-start {
+			total += size
+		} else {
+			total += end - start
+		}
+		if float64(ready) >= p.conf.MinReadyRatio*float64(total) {
+			break
+		}
+	}
+	return end
+}
+
+// 返回选中实例的下标，调用方需持有锁
+func (p *localityPicker) pick() int {
+	end := p.candidates(0)
+	for {
+		for i := 0; i < end; i++ {
+			index := (p.next + i) % end
+			s := p.subConns[index]
+			if p.conf.MaxOutstanding <= 0 || s.outstanding < p.conf.MaxOutstanding {
+				p.next = (index + 1) % end
+				return index
+			}
+		}
+		if end == len(p.subConns) {
+			break
+		}
+		// 当前层级均已过载，溢出到下一层级
+		end = p.candidates(end)
+	}
+	index := p.next % len(p.subConns)
+	p.next = (index + 1) % len(p.subConns)
+	return index
+}
+
+// 统计跨可用区的流量
+func (p *localityPicker) report(index int) {
+	if p.zone == "" {
+		return
+	}
+	addr := p.addrs[index]
+	if addressMeta(addr).Zone == p.zone {
+		metrics.IncrCounter([]string{"client", "zone", addr.ServerName, "in_zone"}, 1)
+	} else {
+		metrics.IncrCounter([]string{"client", "zone", addr.ServerName, "cross_zone"}, 1)
+	}
+}
+
+func (p *localityPicker) Pick(ctx context.Context, opts balancer.PickOptions) (balancer.SubConn, func(balancer.DoneInfo), error) {
+	if len(p.subConns) == 0 {
+		return nil, nil, balancer.ErrNoSubConnAvailable
+	}
+	p.Lock()
+	defer p.Unlock()
+	index := p.pick()
+	p.report(index)
+	s := p.subConns[index]
+	return s.subConn, p.start(s), nil
+}
+
+// 调用方需持有锁
+func (p *localityPicker) start(s *subConnLoad) func(balancer.DoneInfo) {
+	s.outstanding++
+	return func(balancer.DoneInfo) {
+		p.Lock()
+		s.outstanding--
+		p.Unlock()
+	}
+}
+
+func (p *localityPicker) track(sc balancer.SubConn) func(balancer.DoneInfo) {
+	p.Lock()
+	defer p.Unlock()
+	for _, s := range p.subConns {
+		if s.subConn == sc {
+			return p.start(s)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+	"testing"
+	"time"
+)
+
+func TestRTTTiers(t *testing.T) {
+	rtts := map[string]time.Duration{
+		"near":  time.Millisecond,
+		"near2": 3 * time.Millisecond,
+		"far":   20 * time.Millisecond,
+	}
+	tiers := rttTiers([]string{"far", "near", "unknown", "near2"}, rtts, 5*time.Millisecond)
+	want := []int{3, 0, 4, 0}
+	for i := range want {
+		if tiers[i] != want[i] {
+			t.Fatalf("tiers= %v, want %v", tiers, want)
+		}
+	}
+}
+
+func TestLocalityPickerSpillover(t *testing.T) {
+	readySCs := map[resolver.Address]balancer.SubConn{
+		{Addr: "near", Metadata: AddressMeta{Weight: 1, Tier: 0}}: &fakeSubConn{addr: "near"},
+		{Addr: "far", Metadata: AddressMeta{Weight: 1, Tier: 1}}:  &fakeSubConn{addr: "far"},
+	}
+	pick := func(p balancer.Picker) string {
+		sc, _, err := p.Pick(context.Background(), balancer.PickOptions{})
+		if err != nil {
+			t.Fatalf("pick failed, err= %v", err)
+		}
+		return sc.(*fakeSubConn).addr
+	}
+
+	p := newLocalityPicker(readySCs, LocalityConfig{MaxOutstanding: 2}.withDefaults())
+	if pick(p) != "near" || pick(p) != "near" {
+		t.Fatalf("nearest instance should be preferred")
+	}
+	if addr := pick(p); addr != "far" {
+		t.Fatalf("picked %v, want spill to far when near is overloaded", addr)
+	}
+
+	// 最近层级可用实例占比不足MinReadyRatio时同时使用下一层级
+	readySCs = map[resolver.Address]balancer.SubConn{
+		{Addr: "near", Metadata: AddressMeta{Weight: 1, Tier: 0, TierSize: 3}}: &fakeSubConn{addr: "near"},
+		{Addr: "far", Metadata: AddressMeta{Weight: 1, Tier: 1, TierSize: 1}}:  &fakeSubConn{addr: "far"},
+	}
+	p = newLocalityPicker(readySCs, LocalityConfig{}.withDefaults())
+	picked := map[string]bool{pick(p): true, pick(p): true}
+	if !picked["near"] || !picked["far"] {
+		t.Fatalf("picked= %v, want both tiers", picked)
+	}
+	p = newLocalityPicker(readySCs, LocalityConfig{MinReadyRatio: 0.3}.withDefaults())
+	if pick(p) != "near" || pick(p) != "near" {
+		t.Fatalf("near tier should be used alone when ready ratio is above threshold")
+	}
+}
+
+func TestMergeLocality(t *testing.T) {
+	o := &Option{}
+	WithZoneRouting(ZoneConfig{Zone: "a", LocalityConfig: LocalityConfig{MaxOutstanding: 10}})(o)
+	WithCoordinateRouting(CoordinateConfig{LocalityConfig: LocalityConfig{MinReadyRatio: 0.8}})(o)
+	if o.locality.MaxOutstanding != 10 || o.locality.MinReadyRatio != 0.8 || o.zone == nil || o.coordinate == nil {
+		t.Fatalf("locality= %+v, want merged config", o.locality)
+	}
+}
+
+func TestZoneTiers(t *testing.T) {
+	addresses := []resolver.Address{
+		{Addr: "a", Metadata: AddressMeta{Weight: 1, Zone: "us-east-1b"}},
+		{Addr: "b", Metadata: AddressMeta{Weight: 1, Zone: "us-east-1a", Tier: 1}},
+		{Addr: "c", Metadata: AddressMeta{Weight: 1}},
+		{Addr: "d", Metadata: AddressMeta{Weight: 1, Zone: "us-east-1a"}},
+	}
+	zoneTiers(addresses, "us-east-1a")
+	sortByTier(addresses)
+	want := []string{"d", "b", "a", "c"}
+	for i, addr := range addresses {
+		if addr.Addr != want[i] {
+			t.Fatalf("addresses= %v, want order %v", addresses, want)
+		}
+	}
+	if tier := addressMeta(addresses[2]).Tier; tier != 2 {
+		t.Fatalf("cross zone tier= %v, want 2", tier)
+	}
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"github.com/Carey6918/PikaRPC/helper"
+	"github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+	"sort"
+	"strings"
+)
+
+const (
+	WeightMetaKey = "weight" // 服务Meta中覆盖实例权重的key
+	ZoneMetaKey   = "zone"   // 服务Meta中实例所在可用区的key
+)
+
+// AddressMeta ConsulResolver附带在resolver.Address.Metadata中的实例信息
+// 本包的负载均衡交给base balancer前会去掉Metadata，构建picker时再附加回去，见newBalancerBuilder
+type AddressMeta struct {
+	Weight   int
+	Tier     int    // 就近路由的层级，越小越近
+	TierSize int    // 同层级的实例总数(包括未就绪的)，由负载均衡构建picker时设置
+	Zone     string // 实例所在可用区
+	Tags     string // 排序后以换行符拼接的consul tags
+	Meta     string // 排序后以换行符拼接的consul服务Meta，形如"k1=v1\nk2=v2"，值中可以包含逗号
+}
+
+const metaSep = "\n"
+
+func newAddressMeta(s *api.CatalogService) AddressMeta {
+	meta := AddressMeta{
+		Weight: s.ServiceWeights.Passing,
+		Zone:   s.ServiceMeta[ZoneMetaKey],
+	}
+	if w := helper.S2I(s.ServiceMeta[WeightMetaKey]); w > 0 {
+		meta.Weight = w
+	}
+	if meta.Weight <= 0 {
+		meta.Weight = 1
+	}
+	tags := append([]string{}, s.ServiceTags...)
+	sort.Strings(tags)
+	meta.Tags = strings.Join(tags, metaSep)
+	pairs := make([]string, 0, len(s.ServiceMeta))
+	for k, v := range s.ServiceMeta {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	meta.Meta = strings.Join(pairs, metaSep)
+	return meta
+}
+
+func (m AddressMeta) HasTag(tag string) bool {
+	for _, t := range strings.Split(m.Tags, metaSep) {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (m AddressMeta) MetaValue(key string) string {
+	for _, pair := range strings.Split(m.Meta, metaSep) {
+		if strings.HasPrefix(pair, key+"=") {
+			return pair[len(key)+1:]
+		}
+	}
+	return ""
+}
+
+// 取地址上附带的实例信息，没有时返回默认值
+func addressMeta(addr resolver.Address) AddressMeta {
+	if meta, ok := addr.Metadata.(AddressMeta); ok {
+		return meta
+	}
+	return AddressMeta{Weight: 1}
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"code.byted.org/gopkg/pkg/log"
+	"context"
+	"github.com/armon/go-metrics"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"math/rand"
+	"reflect"
+	"time"
+)
+
+// 影子请求携带的metadata key，影子服务可据此识别复制来的流量
+const ShadowMetadataKey = "pika-shadow"
+
+// MirrorConfig 流量复制配置
+type MirrorConfig struct {
+	Target      string        // 影子服务在consul中的服务名
+	Methods     []string      // 需要复制的完整方法名，为空时复制所有方法
+	Percent     float64       // 复制比例[0,100]，为0时不复制
+	DiffPercent float64       // 复制的请求中对比影子响应与主响应的比例[0,100]，不一致时记录，为0时不对比
+	Timeout     time.Duration // 影子请求超时，默认1s
+	MaxInflight int           // 同时进行的影子请求上限，超出时直接丢弃，默认100
+}
+
+func (c MirrorConfig) withDefaults() MirrorConfig {
+	if c.Timeout <= 0 {
+		c.Timeout = time.Second
+	}
+	if c.MaxInflight <= 0 {
+		c.MaxInflight = 100
+	}
+	return c
+}
+
+// 将主请求异步复制到影子服务，影子请求的结果不会返回给调用方
+type mirror struct {
+	service string
+	conf    MirrorConfig
+	methods map[string]bool
+	slots   chan struct{}
+	rand    func() float64
+	invoke  func(ctx context.Context, method string, req, reply interface{}) error // 调用影子服务
+}
+
+func newMirror(service string, conf MirrorConfig) *mirror {
+	conf = conf.withDefaults()
+	m := &mirror{
+		service: service,
+		conf:    conf,
+		methods: make(map[string]bool, len(conf.Methods)),
+		slots:   make(chan struct{}, conf.MaxInflight),
+		rand:    rand.Float64,
+	}
+	m.invoke = m.invokeTarget
+	for _, method := range conf.Methods {
+		m.methods[method] = true
+	}
+	return m
+}
+
+func (m *mirror) sampled(method string) bool {
+	if len(m.methods) > 0 && !m.methods[method] {
+		return false
+	}
+	return hit(m.conf.Percent, m.rand)
+}
+
+// 按百分比抽样，percent<=0时不命中
+func hit(percent float64, rand func() float64) bool {
+	return percent >= 100 || rand()*100 < percent
+}
+
+func (m *mirror) incr(name string) {
+	metrics.IncrCounter([]string{"client", "mirror", m.service, name}, 1)
+}
+
+func (m *mirror) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	reqMsg, ok := req.(proto.Message)
+	if !ok || !m.sampled(method) {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	// 影子请求数已达上限时直接丢弃，不等待
+	select {
+	case m.slots <- struct{}{}:
+	default:
+		m.incr("dropped")
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	// 调用返回后调用方可能修改req，与主请求并行复制一份，两者都只读取req
+	cloned := make(chan proto.Message, 1)
+	go func() {
+		cloned <- proto.Clone(reqMsg)
+	}()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	shadowReq := <-cloned
+	// 只有抽中对比时才复制主响应
+	var primary proto.Message
+	if replyMsg, ok := reply.(proto.Message); ok && err == nil && hit(m.conf.DiffPercent, m.rand) {
+		primary = proto.Clone(replyMsg)
+	}
+	md, _ := metadata.FromOutgoingContext(ctx)
+	go m.shadow(method, shadowReq, reply, primary, md.Copy())
+	return err
+}
+
+func (m *mirror) shadow(method string, req proto.Message, reply interface{}, primary proto.Message, md metadata.MD) {
+	defer func() {
+		<-m.slots
+	}()
+	ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), md), m.conf.Timeout)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, ShadowMetadataKey, "true")
+
+	shadowReply := reflect.New(reflect.TypeOf(reply).Elem()).Interface()
+	m.incr("sent")
+	if err := m.invoke(ctx, method, req, shadowReply); err != nil {
+		m.incr("error")
+		return
+	}
+	if primary == nil {
+		return
+	}
+	if shadowMsg, ok := shadowReply.(proto.Message); ok && proto.Equal(primary, shadowMsg) {
+		m.incr("match")
+		return
+	}
+	m.incr("mismatch")
+	log.Warnf("mirror %v mismatch, %v: %v, %v: %v", method, m.service, primary, m.conf.Target, shadowReply)
+}
+
+func (m *mirror) invokeTarget(ctx context.Context, method string, req, reply interface{}) error {
+	conn, err := GetConn(m.conf.Target)
+	if err != nil {
+		log.Warnf("mirror %v to %v get conn failed, err= %v", method, m.conf.Target, err)
+		return err
+	}
+	return conn.Invoke(ctx, method, req, reply)
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	health "google.golang.org/grpc/health/grpc_health_v1"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMirrorSampling(t *testing.T) {
+	m := newMirror("s", MirrorConfig{Target: "shadow", Methods: []string{"/m"}})
+	m.rand = func() float64 { return 0 }
+	if m.sampled("/m") {
+		t.Fatalf("zero percent should mirror nothing")
+	}
+	m.conf.Percent = 30
+	m.rand = func() float64 { return 0.29 }
+	if !m.sampled("/m") || m.sampled("/other") {
+		t.Fatalf("only configured methods within percent should be mirrored")
+	}
+	m.rand = func() float64 { return 0.3 }
+	if m.sampled("/m") {
+		t.Fatalf("request outside percent should not be mirrored")
+	}
+}
+
+// 影子请求失败或阻塞不影响主请求的结果、错误和耗时
+func TestMirrorIsolation(t *testing.T) {
+	m := newMirror("s", MirrorConfig{Target: "shadow", Percent: 100, DiffPercent: 100, MaxInflight: 1})
+	release := make(chan struct{})
+	var shadows int32
+	m.invoke = func(ctx context.Context, method string, req, reply interface{}) error {
+		atomic.AddInt32(&shadows, 1)
+		<-release
+		return errors.New("shadow failed")
+	}
+	defer close(release)
+
+	primaryErr := errors.New("primary failed")
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		reply.(*health.HealthCheckResponse).Status = health.HealthCheckResponse_SERVING
+		return primaryErr
+	}
+	start := time.Now()
+	reply := &health.HealthCheckResponse{}
+	err := m.unaryInterceptor(context.Background(), "/m", &health.HealthCheckRequest{Service: "a"}, reply, nil, invoker)
+	if err != primaryErr || reply.Status != health.HealthCheckResponse_SERVING {
+		t.Fatalf("err= %v, reply= %v, want primary result", err, reply)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("elapsed= %v, primary should not wait for shadow", elapsed)
+	}
+
+	// 影子请求数已达上限时丢弃，主请求照常完成
+	for atomic.LoadInt32(&shadows) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	ok := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return nil
+	}
+	for i := 0; i < 3; i++ {
+		if err := m.unaryInterceptor(context.Background(), "/m", &health.HealthCheckRequest{}, &health.HealthCheckResponse{}, nil, ok); err != nil {
+			t.Fatalf("err= %v", err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&shadows); n != 1 {
+		t.Fatalf("shadows= %v, want calls dropped while mirror is saturated", n)
+	}
+}
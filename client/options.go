@@ -1,9 +1,31 @@
 package client
 
-import "time"
+import (
+	"github.com/Carey6918/PikaRPC/helper"
+	"google.golang.org/grpc/balancer/roundrobin"
+	"time"
+)
 
 type Option struct {
 	watchInterval time.Duration
+	balancer      string
+	ringHash      *RingHashConfig
+	leastRequest  *LeastRequestConfig
+	locality      *LocalityConfig
+	coordinate    *CoordinateConfig
+	zone          *ZoneConfig
+	outlier       *OutlierConfig
+	caller        string
+	routing       *RoutingConfig
+	session       *SessionConfig
+	shard         *ShardConfig
+	subset        *SubsetConfig
+	mirrors       map[string]MirrorConfig   // 按主服务配置的流量复制
+	throttles     map[string]ThrottleConfig // 按下游服务配置的自适应限流
+	throttle      *ThrottleConfig           // 未单独配置的下游服务使用的自适应限流
+	bulkheads     map[string]BulkheadConfig // 按下游服务配置的并发隔离
+	bulkhead      *BulkheadConfig           // 未单独配置的下游服务使用的并发隔离
+	consul        *helper.ConsulConfig
 }
 
 type Options func(o *Option)
@@ -13,3 +35,192 @@ func WithWatchInterval(interval time.Duration) Options {
 		o.watchInterval = interval
 	}
 }
+
+// 设置服务发现、路由规则等使用的consul配置，默认为helper.DefaultConsulConfig
+func WithConsul(conf helper.ConsulConfig) Options {
+	return func(o *Option) {
+		o.consul = &conf
+	}
+}
+
+// 设置负载均衡策略，默认为round_robin，可选WeightedRoundRobin等通过balancer.Register注册的策略
+func WithBalancer(name string) Options {
+	return func(o *Option) {
+		o.balancer = name
+	}
+}
+
+// 实际使用的负载均衡策略，路由规则、分片路由和会话保持只对本包注册的策略生效
+func (o *Option) balancerName() string {
+	if (o.routing != nil || o.session != nil || o.shard != nil) && o.balancer == roundrobin.Name {
+		return WeightedRoundRobin
+	}
+	return o.balancer
+}
+
+// 实际使用的负载均衡是否读取WithShard、WithShardKey设置的分片信息
+func (o *Option) readsShard() bool {
+	return metaBalancers[o.balancerName()]
+}
+
+// 使用一致性哈希负载均衡，哈希键通过WithHashKey或metadata(HashKeyMetadataKey)设置
+func WithConsistentHash(conf RingHashConfig) Options {
+	return func(o *Option) {
+		conf = conf.withDefaults()
+		o.balancer = ConsistentHash
+		o.ringHash = &conf
+	}
+}
+
+// 使用最少请求(power of two choices)负载均衡
+func WithLeastRequest(conf LeastRequestConfig) Options {
+	return func(o *Option) {
+		conf = conf.withDefaults()
+		o.balancer = LeastRequest
+		o.leastRequest = &conf
+	}
+}
+
+// 按consul网络坐标估算的RTT就近路由，优先访问RTT最小的实例
+func WithCoordinateRouting(conf CoordinateConfig) Options {
+	return func(o *Option) {
+		conf = conf.withDefaults()
+		o.balancer = Locality
+		o.mergeLocality(conf.LocalityConfig)
+		o.coordinate = &conf
+	}
+}
+
+// 可用区就近路由，优先访问同可用区的实例，同可用区可用实例不足或过载时溢出到其他可用区
+// 与WithCoordinateRouting同时使用时，先按可用区再按RTT划分层级，两者的LocalityConfig合并，都设置的字段以后设置的为准
+func WithZoneRouting(conf ZoneConfig) Options {
+	return func(o *Option) {
+		conf = conf.withDefaults()
+		o.balancer = Locality
+		o.mergeLocality(conf.LocalityConfig)
+		o.zone = &conf
+	}
+}
+
+func (o *Option) mergeLocality(conf LocalityConfig) {
+	if o.locality != nil {
+		conf = conf.merge(*o.locality)
+	}
+	o.locality = &conf
+}
+
+// 开启离群实例摘除，conf中未设置的字段使用DefaultOutlierConfig中的默认值
+func WithOutlierDetection(conf OutlierConfig) Options {
+	return func(o *Option) {
+		conf = conf.withDefaults()
+		o.outlier = &conf
+	}
+}
+
+// 设置调用方身份，每次调用都会通过metadata(CallerMetadataKey)传给服务端
+func WithCaller(caller string) Options {
+	return func(o *Option) {
+		o.caller = caller
+	}
+}
+
+// 开启客户端自适应限流，未指定services时对所有下游服务生效
+func WithThrottling(conf ThrottleConfig, services ...string) Options {
+	return func(o *Option) {
+		conf = conf.withDefaults()
+		if len(services) == 0 {
+			o.throttle = &conf
+			return
+		}
+		if o.throttles == nil {
+			o.throttles = make(map[string]ThrottleConfig)
+		}
+		for _, service := range services {
+			o.throttles[service] = conf
+		}
+	}
+}
+
+func (o *Option) throttleConfig(service string) (ThrottleConfig, bool) {
+	if conf, ok := o.throttles[service]; ok {
+		return conf, true
+	}
+	if o.throttle != nil {
+		return *o.throttle, true
+	}
+	return ThrottleConfig{}, false
+}
+
+// 开启下游服务并发隔离，未指定services时每个下游服务各自使用一个conf配置的隔离舱
+func WithBulkhead(conf BulkheadConfig, services ...string) Options {
+	return func(o *Option) {
+		conf = conf.withDefaults()
+		if len(services) == 0 {
+			o.bulkhead = &conf
+			return
+		}
+		if o.bulkheads == nil {
+			o.bulkheads = make(map[string]BulkheadConfig)
+		}
+		for _, service := range services {
+			o.bulkheads[service] = conf
+		}
+	}
+}
+
+func (o *Option) bulkheadConfig(service string) (BulkheadConfig, bool) {
+	if conf, ok := o.bulkheads[service]; ok {
+		return conf, true
+	}
+	if o.bulkhead != nil {
+		return *o.bulkhead, true
+	}
+	return BulkheadConfig{}, false
+}
+
+// 开启路由规则，按方法、metadata、调用方等条件将请求路由到按tags/Meta选出的实例子集
+// 使用默认的round_robin时会改用WeightedRoundRobin，实例权重相同时两者等价
+func WithRouting(conf RoutingConfig) Options {
+	return func(o *Option) {
+		if conf.Interval <= 0 {
+			conf.Interval = 10 * time.Second
+		}
+		o.routing = &conf
+	}
+}
+
+// 开启会话保持，metadata中带有相同会话保持键的请求会发往同一实例
+// 使用默认的round_robin时会改用WeightedRoundRobin，实例权重相同时两者等价
+func WithSessionAffinity(conf SessionConfig) Options {
+	return func(o *Option) {
+		conf = conf.withDefaults()
+		o.session = &conf
+	}
+}
+
+// 配置分片总数和分片函数，用于WithShardKey按key路由到分片
+// 使用默认的round_robin时会改用WeightedRoundRobin，实例权重相同时两者等价
+func WithSharding(conf ShardConfig) Options {
+	return func(o *Option) {
+		conf = conf.withDefaults()
+		o.shard = &conf
+	}
+}
+
+// 开启客户端子集，下游实例很多时每个客户端只连接其中稳定的conf.Size个实例
+func WithSubsetting(conf SubsetConfig) Options {
+	return func(o *Option) {
+		conf = conf.withDefaults()
+		o.subset = &conf
+	}
+}
+
+// 将发往service的部分请求异步复制到conf.Target，影子请求不影响主请求的结果和耗时
+func WithMirror(service string, conf MirrorConfig) Options {
+	return func(o *Option) {
+		if o.mirrors == nil {
+			o.mirrors = make(map[string]MirrorConfig)
+		}
+		o.mirrors[service] = conf.withDefaults()
+	}
+}
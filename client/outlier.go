@@ -0,0 +1,259 @@
+package client
+
+import (
+	"code.byted.org/gopkg/pkg/log"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+	"math"
+	"sync"
+	"time"
+)
+
+// OutlierConfig 离群实例摘除配置
+type OutlierConfig struct {
+	Interval           time.Duration // 统计周期
+	BaseEjectionTime   time.Duration // 基础摘除时长，实际摘除时长 = BaseEjectionTime * 累计摘除次数
+	MaxEjectionTime    time.Duration // 摘除时长上限
+	MaxEjectionPercent int           // 同时被摘除的实例占比上限(%)
+	StdevFactor        float64       // 成功率低于 均值-StdevFactor*标准差 时摘除
+	LatencyStdevFactor float64       // 平均延迟高于 均值+LatencyStdevFactor*标准差 时摘除，为0时不按延迟摘除
+	MinimumHosts       int           // 参与统计的实例数下限，不足时不做摘除
+	RequestVolume      int           // 单个实例在一个统计周期内的请求数下限，不足时不参与统计
+}
+
+func DefaultOutlierConfig() OutlierConfig {
+	return OutlierConfig{
+		Interval:           10 * time.Second,
+		BaseEjectionTime:   30 * time.Second,
+		MaxEjectionTime:    5 * time.Minute,
+		MaxEjectionPercent: 50,
+		StdevFactor:        1.9,
+		MinimumHosts:       3,
+		RequestVolume:      20,
+	}
+}
+
+// 未设置的字段使用默认值
+func (c OutlierConfig) withDefaults() OutlierConfig {
+	def := DefaultOutlierConfig()
+	if c.Interval <= 0 {
+		c.Interval = def.Interval
+	}
+	if c.BaseEjectionTime <= 0 {
+		c.BaseEjectionTime = def.BaseEjectionTime
+	}
+	if c.MaxEjectionTime <= 0 {
+		c.MaxEjectionTime = def.MaxEjectionTime
+	}
+	if c.MaxEjectionPercent <= 0 {
+		c.MaxEjectionPercent = def.MaxEjectionPercent
+	}
+	if c.StdevFactor <= 0 {
+		c.StdevFactor = def.StdevFactor
+	}
+	if c.MinimumHosts <= 0 {
+		c.MinimumHosts = def.MinimumHosts
+	}
+	if c.RequestVolume <= 0 {
+		c.RequestVolume = def.RequestVolume
+	}
+	return c
+}
+
+type hostStats struct {
+	success      int
+	failure      int
+	latency      time.Duration
+	ejected      bool
+	ejectedAt    time.Time
+	ejectionTime time.Duration
+	ejections    int // 累计摘除次数，用于计算退避时长，健康周期内逐步衰减
+}
+
+func (h *hostStats) requests() int {
+	return h.success + h.failure
+}
+
+func (h *hostStats) reset() {
+	h.success, h.failure, h.latency = 0, 0, 0
+}
+
+// 按服务统计每个地址的调用结果，周期性地摘除离群实例
+type outlierDetector struct {
+	sync.Mutex
+	service string
+	conf    OutlierConfig
+	hosts   map[string]*hostStats
+}
+
+func newOutlierDetector(service string, conf OutlierConfig) *outlierDetector {
+	return &outlierDetector{
+		service: service,
+		conf:    conf.withDefaults(),
+		hosts:   make(map[string]*hostStats),
+	}
+}
+
+// 客户端拦截器，记录本次调用实际到达的地址及结果
+func (d *outlierDetector) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	var p peer.Peer
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Peer(&p))...)
+	if p.Addr != nil {
+		d.record(p.Addr.String(), err, time.Since(start))
+	}
+	return err
+}
+
+// 只有表明实例本身异常的错误码才计入失败，业务错误不影响实例健康度
+func isHostFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Internal, codes.Unknown, codes.DataLoss, codes.DeadlineExceeded:
+		return true
+	}
+	return false
+}
+
+func (d *outlierDetector) record(addr string, err error, latency time.Duration) {
+	d.Lock()
+	defer d.Unlock()
+	h, ok := d.hosts[addr]
+	if !ok {
+		h = &hostStats{}
+		d.hosts[addr] = h
+	}
+	if isHostFailure(err) {
+		h.failure++
+	} else {
+		h.success++
+	}
+	h.latency += latency
+}
+
+// 同步最新的解析结果，丢弃已下线地址的统计数据
+func (d *outlierDetector) retain(addrs []resolver.Address) {
+	d.Lock()
+	defer d.Unlock()
+	alive := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		alive[addr.Addr] = true
+		if _, ok := d.hosts[addr.Addr]; !ok {
+			d.hosts[addr.Addr] = &hostStats{}
+		}
+	}
+	for addr := range d.hosts {
+		if !alive[addr] {
+			delete(d.hosts, addr)
+		}
+	}
+}
+
+// 过滤掉被摘除的地址，若全部被摘除则返回原列表，避免无实例可用
+func (d *outlierDetector) filter(addrs []resolver.Address) []resolver.Address {
+	d.Lock()
+	defer d.Unlock()
+	filtered := make([]resolver.Address, 0, len(addrs))
+	for _, addr := range addrs {
+		if h, ok := d.hosts[addr.Addr]; ok && h.ejected {
+			continue
+		}
+		filtered = append(filtered, addr)
+	}
+	if len(filtered) == 0 {
+		return addrs
+	}
+	return filtered
+}
+
+// 执行一次离群检测，返回摘除状态是否发生变化
+func (d *outlierDetector) evaluate(now time.Time) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	changed := false
+	ejected := 0
+	for addr, h := range d.hosts {
+		if !h.ejected {
+			continue
+		}
+		if now.Sub(h.ejectedAt) >= h.ejectionTime {
+			h.ejected = false
+			h.reset()
+			changed = true
+			log.Infof("outlier detection: %v readmit %v after %v", d.service, addr, h.ejectionTime)
+			continue
+		}
+		ejected++
+	}
+
+	candidates := make(map[string]*hostStats)
+	for addr, h := range d.hosts {
+		if !h.ejected && h.requests() >= d.conf.RequestVolume {
+			candidates[addr] = h
+		}
+	}
+	if len(candidates) >= d.conf.MinimumHosts {
+		rates := make([]float64, 0, len(candidates))
+		latencies := make([]float64, 0, len(candidates))
+		for _, h := range candidates {
+			rates = append(rates, float64(h.success)/float64(h.requests()))
+			latencies = append(latencies, float64(h.latency)/float64(h.requests()))
+		}
+		rateMean, rateStdev := meanStdev(rates)
+		latencyMean, latencyStdev := meanStdev(latencies)
+		rateThreshold := rateMean - d.conf.StdevFactor*rateStdev
+		latencyThreshold := latencyMean + d.conf.LatencyStdevFactor*latencyStdev
+
+		maxEjected := len(d.hosts) * d.conf.MaxEjectionPercent / 100
+		for addr, h := range candidates {
+			if ejected >= maxEjected {
+				break
+			}
+			rate := float64(h.success) / float64(h.requests())
+			latency := float64(h.latency) / float64(h.requests())
+			slow := d.conf.LatencyStdevFactor > 0 && latency > latencyThreshold
+			if rate >= rateThreshold && !slow {
+				continue
+			}
+			h.ejections++
+			h.ejected = true
+			h.ejectedAt = now
+			h.ejectionTime = time.Duration(h.ejections) * d.conf.BaseEjectionTime
+			if h.ejectionTime > d.conf.MaxEjectionTime {
+				h.ejectionTime = d.conf.MaxEjectionTime
+			}
+			ejected++
+			changed = true
+			log.Warnf("outlier detection: %v eject %v for %v, success rate= %.3f(threshold %.3f), latency= %v",
+				d.service, addr, h.ejectionTime, rate, rateThreshold, time.Duration(latency))
+		}
+	}
+
+	for _, h := range d.hosts {
+		if !h.ejected && h.ejections > 0 && now.Sub(h.ejectedAt) >= h.ejectionTime+d.conf.Interval {
+			h.ejections--
+		}
+		h.reset()
+	}
+	return changed
+}
+
+func meanStdev(values []float64) (float64, float64) {
+	if len(values) == 0 {
+		return 0, 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var variance float64
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(variance / float64(len(values)))
+}
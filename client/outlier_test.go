@@ -0,0 +1,59 @@
+package client
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestOutlierDetectorEjectAndReadmit(t *testing.T) {
+	addrs := []resolver.Address{{Addr: "10.0.0.1:80"}, {Addr: "10.0.0.2:80"}, {Addr: "10.0.0.3:80"}, {Addr: "10.0.0.4:80"}}
+	d := newOutlierDetector("test", OutlierConfig{
+		BaseEjectionTime: time.Minute,
+		StdevFactor:      1,
+		RequestVolume:    10,
+	})
+	d.retain(addrs)
+
+	invalid := status.Error(codes.InvalidArgument, "invalid argument")
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	for i := 0; i < 10; i++ {
+		d.record("10.0.0.1:80", nil, time.Millisecond)
+		d.record("10.0.0.2:80", nil, time.Millisecond)
+		d.record("10.0.0.3:80", invalid, time.Millisecond)
+		d.record("10.0.0.4:80", unavailable, time.Millisecond)
+	}
+
+	now := time.Now()
+	if !d.evaluate(now) {
+		t.Fatalf("expected ejection")
+	}
+	filtered := d.filter(addrs)
+	if len(filtered) != 3 {
+		t.Fatalf("filtered= %v, want 3 addresses", filtered)
+	}
+	for _, addr := range filtered {
+		if addr.Addr == "10.0.0.4:80" {
+			t.Fatalf("10.0.0.4:80 should be ejected")
+		}
+	}
+
+	if !d.evaluate(now.Add(time.Minute)) {
+		t.Fatalf("expected readmission")
+	}
+	if filtered := d.filter(addrs); len(filtered) != 4 {
+		t.Fatalf("filtered= %v, want 4 addresses", filtered)
+	}
+}
+
+func TestOutlierDetectorNeverEjectsAll(t *testing.T) {
+	addrs := []resolver.Address{{Addr: "10.0.0.1:80"}}
+	d := newOutlierDetector("test", OutlierConfig{})
+	d.retain(addrs)
+	d.hosts["10.0.0.1:80"].ejected = true
+	if filtered := d.filter(addrs); len(filtered) != 1 {
+		t.Fatalf("filtered= %v, want original addresses", filtered)
+	}
+}
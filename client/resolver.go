@@ -5,18 +5,22 @@ import (
 	"github.com/Carey6918/PikaRPC/helper"
 	"github.com/hashicorp/consul/api"
 	"google.golang.org/grpc/resolver"
+	"net"
+	"sort"
 	"sync"
 	"time"
 )
 
 type ConsulResolver struct {
 	sync.RWMutex
-	target  resolver.Target
-	cc      resolver.ClientConn
-	client  *api.Client
-	addr    chan []resolver.Address
-	done    chan struct{}
-	options Option
+	target   resolver.Target
+	cc       resolver.ClientConn
+	client   *api.Client
+	addr     chan []resolver.Address
+	done     chan struct{}
+	options  Option
+	resolved []resolver.Address // 最近一次从consul解析到的地址
+	outlier  *outlierDetector
 }
 
 func (r *ConsulResolver) ResolveNow(resolver.ResolveNowOption) {
@@ -30,10 +34,24 @@ func (r *ConsulResolver) watch() {
 	ticker := time.NewTicker(r.options.watchInterval)
 	defer ticker.Stop()
 
+	// 未开启离群检测时outlierC为nil，不会被选中
+	var outlierC <-chan time.Time
+	if r.outlier != nil {
+		outlierTicker := time.NewTicker(r.outlier.conf.Interval)
+		defer outlierTicker.Stop()
+		outlierC = outlierTicker.C
+	}
+
 	for {
 		select {
 		case <-ticker.C:
 			r.resolve()
+		case now := <-outlierC:
+			if r.outlier.evaluate(now) {
+				r.Lock()
+				r.publish()
+				r.Unlock()
+			}
 		case <-r.done:
 			return
 		}
@@ -61,21 +79,83 @@ func (r *ConsulResolver) resolve() {
 	}
 
 	addresses := make([]resolver.Address, 0, len(services))
+	nodes := make([]string, 0, len(services))
 
 	for _, s := range services {
-
-		address := s.ServiceAddress
-		port := s.ServicePort
-		log.Infof("address= %v, port= %v", address, port)
-		if address == "" {
-			address = s.Address
-		}
-
 		addresses = append(addresses, resolver.Address{
-			Addr:       address + ":" + helper.I2S(port),
+			Addr:       instanceAddress(s),
 			ServerName: r.target.Endpoint,
+			Metadata:   newAddressMeta(s),
 		})
+		nodes = append(nodes, s.Node)
+	}
+	if r.options.coordinate != nil {
+		r.applyCoordinates(addresses, nodes)
+	}
+	if r.options.zone != nil {
+		zoneTiers(addresses, r.options.zone.Zone)
+		sortByTier(addresses)
+	}
+	r.resolved = addresses
+	r.publish()
+}
+
+// 将解析结果推送给gRPC，调用方需持有锁
+func (r *ConsulResolver) publish() {
+	addresses := r.resolved
+	if r.options.subset != nil {
+		addresses = subsetAddresses(addresses, r.options.subset.ClientID, r.options.subset.Size)
+	}
+	if r.outlier != nil {
+		r.outlier.retain(addresses)
+		addresses = r.outlier.filter(addresses)
+	}
+	if !metaBalancers[r.options.balancerName()] {
+		addresses = stripMeta(addresses)
 	}
 	r.addr <- addresses
+}
 
+// 按估算的RTT设置地址层级并由近到远排序，估算失败时保持原顺序
+func (r *ConsulResolver) applyCoordinates(addresses []resolver.Address, nodes []string) {
+	rtts, err := estimateRTT(r.client)
+	if err != nil {
+		log.Warnf("estimate rtt for %v failed, err= %v", r.target.Endpoint, err)
+		return
+	}
+	tiers := rttTiers(nodes, rtts, r.options.coordinate.TierWidth)
+	for i := range addresses {
+		meta := addressMeta(addresses[i])
+		meta.Tier = tiers[i]
+		addresses[i].Metadata = meta
+	}
+	sortByTier(addresses)
+}
+
+func sortByTier(addresses []resolver.Address) {
+	sort.SliceStable(addresses, func(i, j int) bool {
+		return addressMeta(addresses[i]).Tier < addressMeta(addresses[j]).Tier
+	})
+}
+
+// grpc自带的负载均衡以整个resolver.Address作为subConn的key，不读取AddressMeta，
+// 去掉Metadata避免实例信息变化时断开重连
+func stripMeta(addresses []resolver.Address) []resolver.Address {
+	stripped := make([]resolver.Address, 0, len(addresses))
+	for _, addr := range addresses {
+		addr.Metadata = nil
+		stripped = append(stripped, addr)
+	}
+	return stripped
+}
+
+// 实例的host:port，未设置服务地址时使用节点地址，IPv6地址会加上方括号
+func instanceAddress(s *api.CatalogService) string {
+	address := s.ServiceAddress
+	port := s.ServicePort
+	log.Infof("address= %v, port= %v", address, port)
+	if address == "" {
+		address = s.Address
+	}
+	return net.JoinHostPort(address, helper.I2S(port))
 }
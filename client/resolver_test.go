@@ -0,0 +1,23 @@
+package client
+
+import (
+	"github.com/hashicorp/consul/api"
+	"testing"
+)
+
+// 服务地址为空时使用节点地址，IPv6地址需加方括号
+func TestInstanceAddress(t *testing.T) {
+	cases := []struct {
+		service api.CatalogService
+		want    string
+	}{
+		{api.CatalogService{ServiceAddress: "10.0.0.1", ServicePort: 9785}, "10.0.0.1:9785"},
+		{api.CatalogService{Address: "10.0.0.2", ServicePort: 9785}, "10.0.0.2:9785"},
+		{api.CatalogService{ServiceAddress: "fe80::1", ServicePort: 9785}, "[fe80::1]:9785"},
+	}
+	for _, c := range cases {
+		if got := instanceAddress(&c.service); got != c.want {
+			t.Errorf("instanceAddress(%+v)= %v, want %v", c.service, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// 一致性哈希负载均衡的名称，相同哈希键的请求会落到同一实例
+const ConsistentHash = "pika_consistent_hash"
+
+// 携带哈希键的metadata key，优先使用WithHashKey设置在context上的值
+const HashKeyMetadataKey = "pika-hash-key"
+
+// RingHashConfig 一致性哈希配置
+type RingHashConfig struct {
+	VirtualNodes int     // 每单位权重的虚拟节点数，默认100
+	LoadFactor   float64 // 有界负载系数，实例并发超过 平均并发*LoadFactor 时顺延到下一个实例，为0时不限制
+}
+
+func (c RingHashConfig) withDefaults() RingHashConfig {
+	if c.VirtualNodes <= 0 {
+		c.VirtualNodes = 100
+	}
+	if c.LoadFactor > 0 && c.LoadFactor < 1 {
+		c.LoadFactor = 1
+	}
+	return c
+}
+
+type hashKeyCtxKey struct{}
+
+// WithHashKey 在context上设置一致性哈希键
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKeyCtxKey{}, key)
+}
+
+func hashKey(ctx context.Context) (string, bool) {
+	if key, ok := ctx.Value(hashKeyCtxKey{}).(string); ok {
+		return key, true
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		if values := md.Get(HashKeyMetadataKey); len(values) > 0 {
+			return values[0], true
+		}
+	}
+	return "", false
+}
+
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+func newConsistentHashBuilder() balancer.Builder {
+	return newBalancerBuilder(ConsistentHash, &ringHashPickerBuilder{})
+}
+
+type ringHashPickerBuilder struct{}
+
+func (*ringHashPickerBuilder) Build(readySCs map[resolver.Address]balancer.SubConn) balancer.Picker {
+	conf := RingHashConfig{}
+	if GClient != nil && GClient.options.ringHash != nil {
+		conf = *GClient.options.ringHash
+	}
+	return newRingHashPicker(readySCs, conf.withDefaults())
+}
+
+type ringNode struct {
+	hash  uint64
+	index int
+}
+
+type ringHashPicker struct {
+	sync.Mutex
+	conf     RingHashConfig
+	subConns []balancer.SubConn
+	inflight []int
+	total    int
+	ring     []ringNode
+}
+
+// 虚拟节点以实例地址为种子，实例增减时只有相邻区间的键会迁移
+func newRingHashPicker(readySCs map[resolver.Address]balancer.SubConn, conf RingHashConfig) *ringHashPicker {
+	p := &ringHashPicker{conf: conf}
+	for addr, sc := range readySCs {
+		index := len(p.subConns)
+		p.subConns = append(p.subConns, sc)
+		nodes := conf.VirtualNodes * addressMeta(addr).Weight
+		for i := 0; i < nodes; i++ {
+			p.ring = append(p.ring, ringNode{
+				hash:  hash64(addr.Addr + "#" + strconv.Itoa(i)),
+				index: index,
+			})
+		}
+	}
+	p.inflight = make([]int, len(p.subConns))
+	sort.Slice(p.ring, func(i, j int) bool {
+		return p.ring[i].hash < p.ring[j].hash
+	})
+	return p
+}
+
+// 返回顺时针方向第一个未超过负载上限的实例
+func (p *ringHashPicker) lookup(key string) int {
+	h := hash64(key)
+	start := sort.Search(len(p.ring), func(i int) bool {
+		return p.ring[i].hash >= h
+	})
+	if p.conf.LoadFactor <= 0 {
+		return p.ring[start%len(p.ring)].index
+	}
+	limit := int(math.Ceil(float64(p.total+1) / float64(len(p.subConns)) * p.conf.LoadFactor))
+	for i := 0; i < len(p.ring); i++ {
+		index := p.ring[(start+i)%len(p.ring)].index
+		if p.inflight[index] < limit {
+			return index
+		}
+	}
+	return p.ring[start%len(p.ring)].index
+}
+
+func (p *ringHashPicker) Pick(ctx context.Context, opts balancer.PickOptions) (balancer.SubConn, func(balancer.DoneInfo), error) {
+	if len(p.subConns) == 0 {
+		return nil, nil, balancer.ErrNoSubConnAvailable
+	}
+	p.Lock()
+	defer p.Unlock()
+	var index int
+	if key, ok := hashKey(ctx); ok {
+		index = p.lookup(key)
+	} else {
+		// 未设置哈希键时随机选择
+		index = rand.Intn(len(p.subConns))
+	}
+	return p.subConns[index], p.start(index), nil
+}
+
+// 调用方需持有锁
+func (p *ringHashPicker) start(index int) func(balancer.DoneInfo) {
+	p.inflight[index]++
+	p.total++
+	return func(balancer.DoneInfo) {
+		p.Lock()
+		p.inflight[index]--
+		p.total--
+		p.Unlock()
+	}
+}
+
+func (p *ringHashPicker) track(sc balancer.SubConn) func(balancer.DoneInfo) {
+	p.Lock()
+	defer p.Unlock()
+	for i, s := range p.subConns {
+		if s == sc {
+			return p.start(i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+	"strconv"
+	"testing"
+)
+
+func pickAddr(t *testing.T, p balancer.Picker, key string) (string, func(balancer.DoneInfo)) {
+	sc, done, err := p.Pick(WithHashKey(context.Background(), key), balancer.PickOptions{})
+	if err != nil {
+		t.Fatalf("pick failed, err= %v", err)
+	}
+	return sc.(*fakeSubConn).addr, done
+}
+
+func TestRingHashMinimalReshuffle(t *testing.T) {
+	readySCs := make(map[resolver.Address]balancer.SubConn)
+	for _, addr := range []string{"a", "b", "c", "d"} {
+		readySCs[resolver.Address{Addr: addr}] = &fakeSubConn{addr: addr}
+	}
+	before := newRingHashPicker(readySCs, RingHashConfig{}.withDefaults())
+	delete(readySCs, resolver.Address{Addr: "d"})
+	after := newRingHashPicker(readySCs, RingHashConfig{}.withDefaults())
+
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		b, _ := pickAddr(t, before, key)
+		a, _ := pickAddr(t, after, key)
+		if again, _ := pickAddr(t, after, key); again != a {
+			t.Fatalf("key %v picked %v and %v", key, a, again)
+		}
+		if b != "d" && a != b {
+			t.Fatalf("key %v moved from %v to %v", key, b, a)
+		}
+	}
+}
+
+func TestRingHashBoundedLoad(t *testing.T) {
+	readySCs := map[resolver.Address]balancer.SubConn{
+		{Addr: "a"}: &fakeSubConn{addr: "a"},
+		{Addr: "b"}: &fakeSubConn{addr: "b"},
+	}
+	p := newRingHashPicker(readySCs, RingHashConfig{LoadFactor: 1}.withDefaults())
+	first, _ := pickAddr(t, p, "hot")
+	second, done := pickAddr(t, p, "hot")
+	if first == second {
+		t.Fatalf("second in-flight request for hot key should spill to the other instance")
+	}
+	done(balancer.DoneInfo{})
+	if third, _ := pickAddr(t, p, "hot"); third == first {
+		t.Fatalf("third request should spill, first instance is still loaded")
+	}
+}
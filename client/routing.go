@@ -0,0 +1,240 @@
+package client
+
+import (
+	"code.byted.org/gopkg/pkg/log"
+	"context"
+	"github.com/Carey6918/PikaRPC/helper"
+	"github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RoutingConfig 路由规则的来源，同时设置时以consul KV中的规则为准
+type RoutingConfig struct {
+	KVKey    string        // consul KV中路由规则的key，会加上consul配置的Prefix
+	File     string        // 本地YAML路由规则文件
+	Interval time.Duration // 本地文件的检查间隔，默认10s
+}
+
+// RouteTable 路由规则，按顺序匹配，命中第一条规则后将请求路由到其Subset
+type RouteTable struct {
+	Routes []RouteRule `yaml:"Routes"`
+}
+
+// RouteRule 单条路由规则，所有已设置的条件都满足时命中
+type RouteRule struct {
+	Name    string            `yaml:"Name"`
+	Service string            `yaml:"Service"` // 下游服务名，为空时匹配所有服务
+	Method  string            `yaml:"Method"`  // 完整方法名，以*结尾时按前缀匹配，为空时匹配所有方法
+	Headers map[string]string `yaml:"Headers"` // 需全部匹配的metadata
+	Caller  string            `yaml:"Caller"`  // 调用方服务名，即WithCaller设置的值
+	Percent float64           `yaml:"Percent"` // 满足条件的请求中路由到Subset的比例(0,100]，为0时表示100
+	Subset  Subset            `yaml:"Subset"`
+}
+
+// Subset 按consul tags和服务Meta选出的实例子集
+type Subset struct {
+	Tags []string          `yaml:"Tags"` // 需包含全部tag
+	Meta map[string]string `yaml:"Meta"` // 需全部相等
+}
+
+func (s Subset) contains(meta AddressMeta) bool {
+	for _, tag := range s.Tags {
+		if !meta.HasTag(tag) {
+			return false
+		}
+	}
+	for k, v := range s.Meta {
+		if meta.MetaValue(k) != v {
+			return false
+		}
+	}
+	return true
+}
+
+// 子集的唯一标识，用于缓存子集对应的picker
+func (s Subset) key() string {
+	tags := append([]string{}, s.Tags...)
+	sort.Strings(tags)
+	pairs := make([]string, 0, len(s.Meta))
+	for k, v := range s.Meta {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(tags, ",") + "|" + strings.Join(pairs, ",")
+}
+
+func (r *RouteRule) match(service, method, caller string, header metadata.MD) bool {
+	if r.Service != "" && r.Service != service {
+		return false
+	}
+	if strings.HasSuffix(r.Method, "*") {
+		if !strings.HasPrefix(method, strings.TrimSuffix(r.Method, "*")) {
+			return false
+		}
+	} else if r.Method != "" && r.Method != method {
+		return false
+	}
+	if r.Caller != "" && r.Caller != caller {
+		return false
+	}
+	for k, v := range r.Headers {
+		values := header.Get(k)
+		if len(values) == 0 || values[0] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Router 持有当前生效的路由规则，规则变化时无需重建连接
+type Router struct {
+	sync.RWMutex
+	table  RouteTable
+	caller string
+	rand   func() float64
+}
+
+func newRouter(caller string) *Router {
+	return &Router{
+		caller: caller,
+		rand:   rand.Float64,
+	}
+}
+
+// Update 替换当前的路由规则
+func (r *Router) Update(table RouteTable) {
+	r.Lock()
+	defer r.Unlock()
+	r.table = table
+}
+
+// route 返回请求应路由到的子集，未命中任何规则时返回false
+func (r *Router) route(service, method string, header metadata.MD) (Subset, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	for i := range r.table.Routes {
+		rule := &r.table.Routes[i]
+		if !rule.match(service, method, r.caller, header) {
+			continue
+		}
+		if rule.Percent > 0 && rule.Percent < 100 && r.rand()*100 >= rule.Percent {
+			continue
+		}
+		return rule.Subset, true
+	}
+	return Subset{}, false
+}
+
+func (r *Router) load(source string, data []byte) {
+	var table RouteTable
+	if err := yaml.Unmarshal(data, &table); err != nil {
+		log.Errorf("parse route table from %v failed, keep current routes, err= %v", source, err)
+		return
+	}
+	r.Update(table)
+	log.Infof("route table updated from %v, routes= %v", source, len(table.Routes))
+}
+
+func (r *Router) watchKV(key string, consul helper.ConsulConfig, done <-chan struct{}) {
+	client, err := consul.NewClient()
+	if err != nil {
+		log.Errorf("consul new client failed, routes from kv %v disabled, err= %v", key, err)
+		return
+	}
+	helper.WatchKV(client, key, done, func(pair *api.KVPair) {
+		if pair == nil {
+			log.Warnf("route table kv %v not found, keep current routes", key)
+			return
+		}
+		r.load("kv "+key, pair.Value)
+	})
+}
+
+// 开始加载路由规则
+func (r *Router) start(conf RoutingConfig, consul helper.ConsulConfig, done <-chan struct{}) {
+	if conf.File != "" {
+		// 本地文件同步加载一次，保证首次调用时已有规则
+		if data, err := ioutil.ReadFile(conf.File); err == nil {
+			r.load(conf.File, data)
+		}
+		if conf.KVKey == "" {
+			go helper.WatchFile(conf.File, conf.Interval, done, func(data []byte) {
+				r.load(conf.File, data)
+			})
+		}
+	}
+	if conf.KVKey != "" {
+		go r.watchKV(consul.Key(conf.KVKey), consul, done)
+	}
+}
+
+// 为负载均衡加上路由规则，未开启路由时直接使用原picker
+// all为全部实例上的picker
+func newRoutedPicker(inner base.PickerBuilder, readySCs map[resolver.Address]balancer.SubConn, all balancer.Picker) balancer.Picker {
+	if GClient == nil || GClient.router == nil {
+		return all
+	}
+	p := &routedPicker{
+		inner:    inner,
+		router:   GClient.router,
+		readySCs: readySCs,
+		all:      all,
+		subsets:  make(map[string]balancer.Picker),
+	}
+	for addr := range readySCs {
+		p.service = addr.ServerName
+		break
+	}
+	return p
+}
+
+// 按路由规则选出子集，再由原负载均衡策略在子集内选择实例
+type routedPicker struct {
+	sync.Mutex
+	inner    base.PickerBuilder
+	router   *Router
+	service  string
+	readySCs map[resolver.Address]balancer.SubConn
+	all      balancer.Picker
+	subsets  map[string]balancer.Picker // 子集对应的picker，按需创建
+}
+
+func (p *routedPicker) subset(subset Subset) balancer.Picker {
+	key := subset.key()
+	p.Lock()
+	defer p.Unlock()
+	if picker, ok := p.subsets[key]; ok {
+		return picker
+	}
+	scs := make(map[resolver.Address]balancer.SubConn)
+	for addr, sc := range p.readySCs {
+		if subset.contains(addressMeta(addr)) {
+			scs[addr] = sc
+		}
+	}
+	// 子集内没有可用实例时退回全部实例
+	picker := p.all
+	if len(scs) > 0 {
+		picker = p.inner.Build(scs)
+	}
+	p.subsets[key] = picker
+	return picker
+}
+
+func (p *routedPicker) Pick(ctx context.Context, opts balancer.PickOptions) (balancer.SubConn, func(balancer.DoneInfo), error) {
+	subset, ok := p.router.route(p.service, opts.FullMethodName, opts.Header)
+	if !ok {
+		return p.all.Pick(ctx, opts)
+	}
+	return p.subset(subset).Pick(ctx, opts)
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"testing"
+)
+
+const testRoutes = `
+Routes:
+- Name: canary-header
+  Method: "/add.AddService/*"
+  Headers:
+    x-canary: "true"
+  Subset:
+    Tags: ["canary"]
+- Name: v2-caller
+  Service: "carey.is.genius"
+  Caller: "carey.web"
+  Subset:
+    Meta:
+      version: "v2"
+`
+
+func TestRouterRoute(t *testing.T) {
+	r := newRouter("carey.web")
+	r.load("test", []byte(testRoutes))
+
+	subset, ok := r.route("carey.is.genius", "/add.AddService/Add", metadata.Pairs("x-canary", "true"))
+	if !ok || len(subset.Tags) != 1 || subset.Tags[0] != "canary" {
+		t.Fatalf("subset= %v, want canary", subset)
+	}
+	subset, ok = r.route("carey.is.genius", "/add.AddService/Add", nil)
+	if !ok || subset.Meta["version"] != "v2" {
+		t.Fatalf("subset= %v, want version v2", subset)
+	}
+	if _, ok := r.route("other.service", "/other.Service/Call", nil); ok {
+		t.Fatalf("request should not match any route")
+	}
+
+	// 按比例路由
+	r.Update(RouteTable{Routes: []RouteRule{{Percent: 10, Subset: Subset{Tags: []string{"canary"}}}}})
+	r.rand = func() float64 { return 0.05 }
+	if _, ok := r.route("s", "/m", nil); !ok {
+		t.Fatalf("request within percent should match")
+	}
+	r.rand = func() float64 { return 0.5 }
+	if _, ok := r.route("s", "/m", nil); ok {
+		t.Fatalf("request beyond percent should not match")
+	}
+}
+
+func TestRoutedPickerSubset(t *testing.T) {
+	readySCs := map[resolver.Address]balancer.SubConn{
+		{Addr: "stable", ServerName: "s", Metadata: AddressMeta{Weight: 1, Meta: "version=v1"}}:                     &fakeSubConn{addr: "stable"},
+		{Addr: "canary", ServerName: "s", Metadata: AddressMeta{Weight: 1, Tags: "canary\nv2", Meta: "version=v2"}}: &fakeSubConn{addr: "canary"},
+	}
+	GClient = &Client{options: defaultOption(), router: newRouter("")}
+	defer func() { GClient = nil }()
+	GClient.router.Update(RouteTable{Routes: []RouteRule{
+		{Headers: map[string]string{"x-canary": "true"}, Subset: Subset{Tags: []string{"canary"}}},
+		{Headers: map[string]string{"x-gray": "true"}, Subset: Subset{Tags: []string{"gray"}}},
+	}})
+	p := newRoutedPicker(&wrrPickerBuilder{}, readySCs, (&wrrPickerBuilder{}).Build(readySCs))
+
+	pick := func(header metadata.MD) string {
+		sc, _, err := p.Pick(context.Background(), balancer.PickOptions{FullMethodName: "/m", Header: header})
+		if err != nil {
+			t.Fatalf("pick failed, err= %v", err)
+		}
+		return sc.(*fakeSubConn).addr
+	}
+	for i := 0; i < 4; i++ {
+		if addr := pick(metadata.Pairs("x-canary", "true")); addr != "canary" {
+			t.Fatalf("picked %v, want canary", addr)
+		}
+	}
+	picked := map[string]bool{pick(nil): true, pick(nil): true}
+	if !picked["stable"] || !picked["canary"] {
+		t.Fatalf("picked= %v, unmatched requests should use all instances", picked)
+	}
+	// 子集为空时退回全部实例
+	picked = map[string]bool{pick(metadata.Pairs("x-gray", "true")): true, pick(metadata.Pairs("x-gray", "true")): true}
+	if len(picked) != 2 {
+		t.Fatalf("picked= %v, empty subset should fall back to all instances", picked)
+	}
+}
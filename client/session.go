@@ -0,0 +1,176 @@
+package client
+
+import (
+	"code.byted.org/gopkg/pkg/log"
+	"context"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+	"sync"
+	"time"
+)
+
+// 携带会话保持键的默认metadata key
+const SessionMetadataKey = "pika-session"
+
+// SessionConfig 会话保持配置
+type SessionConfig struct {
+	MetadataKey string        // 携带会话保持键的metadata key，默认SessionMetadataKey
+	TTL         time.Duration // 会话在最后一次请求后保留的时长，默认10分钟
+	// 绑定的实例不可用超过该时长才改绑其他实例，期间请求临时发往其他实例，默认5s
+	Grace time.Duration
+	// OnFailover 会话绑定的实例不可用、改为绑定其他实例时回调，回调中不要执行耗时操作
+	OnFailover func(service, key, from, to string)
+}
+
+func (c SessionConfig) withDefaults() SessionConfig {
+	if c.MetadataKey == "" {
+		c.MetadataKey = SessionMetadataKey
+	}
+	if c.TTL <= 0 {
+		c.TTL = 10 * time.Minute
+	}
+	if c.Grace <= 0 {
+		c.Grace = 5 * time.Second
+	}
+	return c
+}
+
+type session struct {
+	addr        string
+	expires     time.Time
+	unavailable time.Time // 绑定的实例开始不可用的时间，可用时为零值
+}
+
+// 记录每个服务下会话绑定的实例，picker重建后仍然保留
+type sessionTable struct {
+	sync.Mutex
+	conf      SessionConfig
+	sessions  map[string]map[string]*session // service -> key -> session
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newSessionTable(conf SessionConfig) *sessionTable {
+	return &sessionTable{
+		conf:      conf.withDefaults(),
+		sessions:  make(map[string]map[string]*session),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// 清理过期会话，调用方需持有锁
+func (t *sessionTable) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < t.conf.TTL {
+		return
+	}
+	for service, sessions := range t.sessions {
+		for key, s := range sessions {
+			if now.After(s.expires) {
+				delete(sessions, key)
+			}
+		}
+		if len(sessions) == 0 {
+			delete(t.sessions, service)
+		}
+	}
+	t.lastSweep = now
+}
+
+// 返回会话当前绑定的可用实例；绑定的实例不可用时，超过Grace或没有会话才返回rebind=true
+func (t *sessionTable) get(service, key string, now time.Time, ready func(addr string) bool) (addr string, rebind bool) {
+	t.Lock()
+	defer t.Unlock()
+	t.sweep(now)
+	s, ok := t.sessions[service][key]
+	if !ok || now.After(s.expires) {
+		return "", true
+	}
+	s.expires = now.Add(t.conf.TTL)
+	if ready(s.addr) {
+		s.unavailable = time.Time{}
+		return s.addr, false
+	}
+	if s.unavailable.IsZero() {
+		s.unavailable = now
+	}
+	return "", now.Sub(s.unavailable) >= t.conf.Grace
+}
+
+// 绑定会话到实例，返回之前绑定的实例
+func (t *sessionTable) bind(service, key, addr string, now time.Time) string {
+	t.Lock()
+	defer t.Unlock()
+	sessions, ok := t.sessions[service]
+	if !ok {
+		sessions = make(map[string]*session)
+		t.sessions[service] = sessions
+	}
+	var from string
+	if s, ok := sessions[key]; ok && !now.After(s.expires) {
+		from = s.addr
+	}
+	sessions[key] = &session{addr: addr, expires: now.Add(t.conf.TTL)}
+	return from
+}
+
+// all为全部实例上的picker，请求发往绑定的实例时计入它的负载
+func (t *sessionTable) picker(readySCs map[resolver.Address]balancer.SubConn, inner, all balancer.Picker) balancer.Picker {
+	p := &sessionPicker{
+		table:    t,
+		inner:    inner,
+		all:      all,
+		subConns: make(map[string]balancer.SubConn, len(readySCs)),
+		addrs:    make(map[balancer.SubConn]string, len(readySCs)),
+	}
+	for addr, sc := range readySCs {
+		p.service = addr.ServerName
+		p.subConns[addr.Addr] = sc
+		p.addrs[sc] = addr.Addr
+	}
+	return p
+}
+
+// 带会话保持键的请求优先发往会话绑定的实例，实例不可用超过Grace时由内层picker重新选择并改绑
+type sessionPicker struct {
+	table    *sessionTable
+	inner    balancer.Picker
+	all      balancer.Picker
+	service  string
+	subConns map[string]balancer.SubConn
+	addrs    map[balancer.SubConn]string
+}
+
+func (p *sessionPicker) Pick(ctx context.Context, opts balancer.PickOptions) (balancer.SubConn, func(balancer.DoneInfo), error) {
+	values := opts.Header.Get(p.table.conf.MetadataKey)
+	if len(values) == 0 || values[0] == "" {
+		return p.inner.Pick(ctx, opts)
+	}
+	key := values[0]
+	now := p.table.now()
+	addr, rebind := p.table.get(p.service, key, now, func(addr string) bool {
+		_, ok := p.subConns[addr]
+		return ok
+	})
+	if addr != "" {
+		sc := p.subConns[addr]
+		var done func(balancer.DoneInfo)
+		if tracker, ok := p.all.(loadTracker); ok {
+			done = tracker.track(sc)
+		}
+		return sc, done, nil
+	}
+	sc, done, err := p.inner.Pick(ctx, opts)
+	if err != nil || !rebind {
+		// 绑定的实例短暂不可用时临时发往其他实例，不改绑
+		return sc, done, err
+	}
+	addr = p.addrs[sc]
+	if from := p.table.bind(p.service, key, addr, now); from != "" && from != addr {
+		log.Warnf("session %v of %v failover from %v to %v", key, p.service, from, addr)
+		if p.table.conf.OnFailover != nil {
+			p.table.conf.OnFailover(p.service, key, from, addr)
+		}
+	}
+	return sc, done, nil
+}
@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+	"testing"
+	"time"
+)
+
+func TestSessionPickerFailover(t *testing.T) {
+	var failovers []string
+	table := newSessionTable(SessionConfig{
+		Grace: time.Second,
+		OnFailover: func(service, key, from, to string) {
+			failovers = append(failovers, key+":"+from+"->"+to)
+		},
+	})
+	now := time.Now()
+	table.now = func() time.Time { return now }
+	build := func(addrs ...string) balancer.Picker {
+		readySCs := make(map[resolver.Address]balancer.SubConn)
+		for _, addr := range addrs {
+			readySCs[resolver.Address{Addr: addr, ServerName: "s", Metadata: AddressMeta{Weight: 1}}] = &fakeSubConn{addr: addr}
+		}
+		all := (&wrrPickerBuilder{}).Build(readySCs)
+		return table.picker(readySCs, all, all)
+	}
+	pick := func(p balancer.Picker, key string) string {
+		sc, _, err := p.Pick(context.Background(), balancer.PickOptions{Header: metadata.Pairs(SessionMetadataKey, key)})
+		if err != nil {
+			t.Fatalf("pick failed, err= %v", err)
+		}
+		return sc.(*fakeSubConn).addr
+	}
+
+	p := build("a", "b", "c")
+	pinned := pick(p, "session-1")
+	for i := 0; i < 5; i++ {
+		if addr := pick(p, "session-1"); addr != pinned {
+			t.Fatalf("session picked %v, want pinned %v", addr, pinned)
+		}
+	}
+
+	// 绑定的实例短暂不可用时临时发往其他实例，恢复后仍发往绑定的实例
+	var rest []string
+	for _, addr := range []string{"a", "b", "c"} {
+		if addr != pinned {
+			rest = append(rest, addr)
+		}
+	}
+	if addr := pick(build(rest...), "session-1"); addr == pinned || len(failovers) != 0 {
+		t.Fatalf("picked %v, failovers= %v, want temporary pick without rebinding", addr, failovers)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if addr := pick(p, "session-1"); addr != pinned {
+		t.Fatalf("session picked %v, want pinned %v after recovery", addr, pinned)
+	}
+
+	// 绑定的实例不可用超过Grace后改绑其他实例并回调
+	p = build(rest...)
+	pick(p, "session-1")
+	now = now.Add(time.Second)
+	moved := pick(p, "session-1")
+	if moved == pinned {
+		t.Fatalf("session should fail over from %v", pinned)
+	}
+	if len(failovers) != 1 || failovers[0] != "session-1:"+pinned+"->"+moved {
+		t.Fatalf("failovers= %v", failovers)
+	}
+	if addr := pick(p, "session-1"); addr != moved {
+		t.Fatalf("session picked %v, want new pinned %v", addr, moved)
+	}
+}
+
+// 发往绑定实例的请求计入内层负载均衡的负载
+func TestSessionPickerTracksLoad(t *testing.T) {
+	table := newSessionTable(SessionConfig{})
+	readySCs := map[resolver.Address]balancer.SubConn{
+		{Addr: "a", ServerName: "s"}: &fakeSubConn{addr: "a"},
+		{Addr: "b", ServerName: "s"}: &fakeSubConn{addr: "b"},
+	}
+	all := (&leastRequestPickerBuilder{}).Build(readySCs).(*leastRequestPicker)
+	p := table.picker(readySCs, all, all)
+	header := balancer.PickOptions{Header: metadata.Pairs(SessionMetadataKey, "session-1")}
+	var dones []func(balancer.DoneInfo)
+	for i := 0; i < 3; i++ {
+		_, done, err := p.Pick(context.Background(), header)
+		if err != nil || done == nil {
+			t.Fatalf("pick failed, done= %v, err= %v", done != nil, err)
+		}
+		dones = append(dones, done)
+	}
+	outstanding := 0
+	for _, s := range all.subConns {
+		outstanding += s.outstanding
+	}
+	if outstanding != 3 {
+		t.Fatalf("outstanding= %v, want pinned picks counted", outstanding)
+	}
+	for _, done := range dones {
+		done(balancer.DoneInfo{})
+	}
+}
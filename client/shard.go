@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 服务Meta中实例所拥有分片的key，值为逗号分隔的分片ID，如"0,3,5"
+const ShardsMetaKey = "shards"
+
+// Partitioner 将key映射到[0, count)中的分片ID
+type Partitioner func(key string, count int) int
+
+// HashPartitioner 默认的分片函数，按key的哈希取模
+func HashPartitioner(key string, count int) int {
+	return int(hash64(key) % uint64(count))
+}
+
+// ShardConfig 按key路由时使用的分片配置
+type ShardConfig struct {
+	Count       int         // 分片总数
+	Partitioner Partitioner // 默认HashPartitioner
+}
+
+func (c ShardConfig) withDefaults() ShardConfig {
+	if c.Partitioner == nil {
+		c.Partitioner = HashPartitioner
+	}
+	return c
+}
+
+// OwnsShard 判断实例是否拥有该分片
+func (m AddressMeta) OwnsShard(shard int) bool {
+	for _, id := range strings.Split(m.MetaValue(ShardsMetaKey), ",") {
+		if id, err := strconv.Atoi(strings.TrimSpace(id)); err == nil && id == shard {
+			return true
+		}
+	}
+	return false
+}
+
+type shardCtxKey struct{}
+
+type shardKeyCtxKey struct{}
+
+// WithShard 将请求发往拥有该分片的实例，需通过client.WithSharding开启分片路由或使用本包注册的负载均衡
+func WithShard(ctx context.Context, shard int) context.Context {
+	return context.WithValue(ctx, shardCtxKey{}, shard)
+}
+
+// WithShardKey 将请求发往拥有key所在分片的实例，需通过client.WithSharding配置分片总数
+func WithShardKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, shardKeyCtxKey{}, key)
+}
+
+func shardFromContext(ctx context.Context) (int, bool, error) {
+	if shard, ok := ctx.Value(shardCtxKey{}).(int); ok {
+		return shard, true, nil
+	}
+	key, ok := ctx.Value(shardKeyCtxKey{}).(string)
+	if !ok {
+		return 0, false, nil
+	}
+	if GClient == nil || GClient.options.shard == nil || GClient.options.shard.Count <= 0 {
+		return 0, false, status.Errorf(codes.FailedPrecondition, "shard key %v used without client.WithSharding", key)
+	}
+	conf := GClient.options.shard
+	return conf.Partitioner(key, conf.Count), true, nil
+}
+
+// 负载均衡不读取分片信息时，带分片信息的请求返回FailedPrecondition，避免被发往任意实例
+func shardUnsupportedInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	_, shard := ctx.Value(shardCtxKey{}).(int)
+	_, key := ctx.Value(shardKeyCtxKey{}).(string)
+	if shard || key {
+		return status.Errorf(codes.FailedPrecondition, "shard requested for %v without client.WithSharding", method)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// 带分片信息的请求只会发往拥有该分片的实例，其余请求交给next
+type shardPicker struct {
+	sync.Mutex
+	inner    base.PickerBuilder
+	readySCs map[resolver.Address]balancer.SubConn
+	next     balancer.Picker
+	service  string
+	owners   map[int]balancer.Picker // 分片对应的picker，按需创建，无可用实例时为nil
+}
+
+func newShardPicker(inner base.PickerBuilder, readySCs map[resolver.Address]balancer.SubConn, next balancer.Picker) balancer.Picker {
+	p := &shardPicker{
+		inner:    inner,
+		readySCs: readySCs,
+		next:     next,
+		owners:   make(map[int]balancer.Picker),
+	}
+	for addr := range readySCs {
+		p.service = addr.ServerName
+		break
+	}
+	return p
+}
+
+func (p *shardPicker) owner(shard int) balancer.Picker {
+	p.Lock()
+	defer p.Unlock()
+	if picker, ok := p.owners[shard]; ok {
+		return picker
+	}
+	scs := make(map[resolver.Address]balancer.SubConn)
+	for addr, sc := range p.readySCs {
+		if addressMeta(addr).OwnsShard(shard) {
+			scs[addr] = sc
+		}
+	}
+	var picker balancer.Picker
+	if len(scs) > 0 {
+		picker = p.inner.Build(scs)
+	}
+	p.owners[shard] = picker
+	return picker
+}
+
+func (p *shardPicker) Pick(ctx context.Context, opts balancer.PickOptions) (balancer.SubConn, func(balancer.DoneInfo), error) {
+	shard, ok, err := shardFromContext(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return p.next.Pick(ctx, opts)
+	}
+	picker := p.owner(shard)
+	if picker == nil {
+		return nil, nil, status.Errorf(codes.Unavailable, "no healthy owner of shard %v for %v", shard, p.service)
+	}
+	return picker.Pick(ctx, opts)
+}
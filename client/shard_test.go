@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/roundrobin"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+	"testing"
+)
+
+func TestShardPicker(t *testing.T) {
+	readySCs := map[resolver.Address]balancer.SubConn{
+		{Addr: "a", ServerName: "s", Metadata: AddressMeta{Weight: 1, Meta: "shards=0,2"}}: &fakeSubConn{addr: "a"},
+		{Addr: "b", ServerName: "s", Metadata: AddressMeta{Weight: 1, Meta: "shards=1"}}:   &fakeSubConn{addr: "b"},
+	}
+	GClient = &Client{options: defaultOption()}
+	WithSharding(ShardConfig{Count: 4, Partitioner: func(key string, count int) int {
+		return len(key) % count
+	}})(GClient.options)
+	defer func() { GClient = nil }()
+	p := newShardPicker(&wrrPickerBuilder{}, readySCs, (&wrrPickerBuilder{}).Build(readySCs))
+
+	pick := func(ctx context.Context) (string, error) {
+		sc, _, err := p.Pick(ctx, balancer.PickOptions{})
+		if err != nil {
+			return "", err
+		}
+		return sc.(*fakeSubConn).addr, nil
+	}
+	for shard, want := range map[int]string{0: "a", 1: "b", 2: "a"} {
+		if addr, err := pick(WithShard(context.Background(), shard)); err != nil || addr != want {
+			t.Fatalf("shard %v picked %v, err= %v, want %v", shard, addr, err, want)
+		}
+	}
+	if addr, err := pick(WithShardKey(context.Background(), "k")); err != nil || addr != "b" {
+		t.Fatalf("shard key picked %v, err= %v, want b", addr, err)
+	}
+	if _, err := pick(WithShard(context.Background(), 3)); status.Code(err) != codes.Unavailable {
+		t.Fatalf("shard without owner, err= %v, want Unavailable", err)
+	}
+	if _, err := pick(context.Background()); err != nil {
+		t.Fatalf("request without shard should use all instances, err= %v", err)
+	}
+}
+
+func TestBalancerNameUpgrade(t *testing.T) {
+	o := defaultOption()
+	if o.balancerName() != roundrobin.Name {
+		t.Fatalf("default balancer= %v, want round_robin", o.balancerName())
+	}
+	WithSharding(ShardConfig{Count: 4})(o)
+	if o.balancerName() != WeightedRoundRobin {
+		t.Fatalf("balancer= %v, want upgraded to %v for sharding", o.balancerName(), WeightedRoundRobin)
+	}
+}
+
+// 负载均衡不读取分片信息时，带分片的请求返回错误而不是发往任意实例
+func TestShardWithoutSharding(t *testing.T) {
+	o := defaultOption()
+	if o.readsShard() {
+		t.Fatalf("round_robin should not read shard")
+	}
+	invoked := false
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		invoked = true
+		return nil
+	}
+	err := shardUnsupportedInterceptor(WithShard(context.Background(), 1), "/s/m", nil, nil, nil, invoker)
+	if status.Code(err) != codes.FailedPrecondition || invoked {
+		t.Fatalf("err= %v, invoked= %v, want FailedPrecondition", err, invoked)
+	}
+	if err := shardUnsupportedInterceptor(context.Background(), "/s/m", nil, nil, nil, invoker); err != nil || !invoked {
+		t.Fatalf("request without shard should pass, err= %v", err)
+	}
+
+	WithSharding(ShardConfig{Count: 4})(o)
+	if !o.readsShard() {
+		t.Fatalf("balancer should read shard with sharding")
+	}
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"github.com/Carey6918/PikaRPC/helper"
+	"google.golang.org/grpc/resolver"
+	"os"
+	"sort"
+)
+
+// SubsetConfig 客户端子集配置，每个客户端只连接解析结果中稳定的Size个实例
+type SubsetConfig struct {
+	Size     int    // 子集大小
+	ClientID string // 客户端标识，决定选中哪些实例，默认为主机名与本机IP
+}
+
+func (c SubsetConfig) withDefaults() SubsetConfig {
+	if c.ClientID == "" {
+		hostname, _ := os.Hostname()
+		c.ClientID = hostname + "/" + helper.GetLocalIP()
+	}
+	return c
+}
+
+// 按rendezvous hash选出得分最高的size个实例：
+// 不同客户端的子集均匀分布在所有实例上，实例增减时只有涉及的实例会进出子集
+func subsetAddresses(addrs []resolver.Address, clientID string, size int) []resolver.Address {
+	if size <= 0 || len(addrs) <= size {
+		return addrs
+	}
+	type scored struct {
+		addr  resolver.Address
+		score uint64
+	}
+	all := make([]scored, 0, len(addrs))
+	for _, addr := range addrs {
+		all = append(all, scored{addr: addr, score: hash64(clientID + "|" + addr.Addr)})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].score > all[j].score
+	})
+	subset := make([]resolver.Address, 0, size)
+	for _, s := range all[:size] {
+		subset = append(subset, s.addr)
+	}
+	// 保持原有顺序，避免打乱就近路由的层级顺序
+	order := make(map[string]int, len(addrs))
+	for i, addr := range addrs {
+		order[addr.Addr] = i
+	}
+	sort.Slice(subset, func(i, j int) bool {
+		return order[subset[i].Addr] < order[subset[j].Addr]
+	})
+	return subset
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"google.golang.org/grpc/resolver"
+	"strconv"
+	"testing"
+)
+
+func TestSubsetAddresses(t *testing.T) {
+	var addrs []resolver.Address
+	for i := 0; i < 100; i++ {
+		addrs = append(addrs, resolver.Address{Addr: "10.0.0." + strconv.Itoa(i) + ":80"})
+	}
+
+	// 每个实例被选中的次数大致均匀
+	load := make(map[string]int)
+	for c := 0; c < 1000; c++ {
+		subset := subsetAddresses(addrs, "client-"+strconv.Itoa(c), 10)
+		if len(subset) != 10 {
+			t.Fatalf("subset size= %v, want 10", len(subset))
+		}
+		for _, addr := range subset {
+			load[addr.Addr]++
+		}
+	}
+	for addr, n := range load {
+		if n < 50 || n > 150 {
+			t.Fatalf("%v is selected by %v clients, want about 100", addr, n)
+		}
+	}
+
+	// 移除一个实例时最多替换一个子集成员
+	before := subsetAddresses(addrs, "client-1", 10)
+	after := subsetAddresses(addrs[1:], "client-1", 10)
+	kept := make(map[string]bool)
+	for _, addr := range after {
+		kept[addr.Addr] = true
+	}
+	changed := 0
+	for _, addr := range before {
+		if !kept[addr.Addr] {
+			changed++
+		}
+	}
+	if changed > 1 {
+		t.Fatalf("%v members changed after removing one address", changed)
+	}
+}
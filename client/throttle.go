@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 客户端本地限流的错误码，不属于gRPC标准错误码，只会在本地产生，不会由服务端返回
+const CodeLocalThrottled codes.Code = 100
+
+// IsLocalThrottled 判断错误是否为客户端自适应限流在本地拒绝
+func IsLocalThrottled(err error) bool {
+	return status.Code(err) == CodeLocalThrottled
+}
+
+// ThrottleConfig 客户端自适应限流配置
+// 拒绝概率 = max(0, (requests - K*accepts) / (requests + 1))
+type ThrottleConfig struct {
+	K      float64       // 倍数越小越激进，默认2
+	Window time.Duration // 统计窗口，默认2分钟
+}
+
+func (c ThrottleConfig) withDefaults() ThrottleConfig {
+	if c.K <= 0 {
+		c.K = 2
+	}
+	if c.Window <= 0 {
+		c.Window = 2 * time.Minute
+	}
+	return c
+}
+
+const throttleBuckets = 10
+
+type throttleBucket struct {
+	start    time.Time
+	requests int
+	accepts  int
+}
+
+// 按服务统计窗口内的请求数与被后端接受的请求数
+type throttler struct {
+	sync.Mutex
+	service string
+	conf    ThrottleConfig
+	buckets [throttleBuckets]throttleBucket
+	rand    func() float64
+}
+
+func newThrottler(service string, conf ThrottleConfig) *throttler {
+	return &throttler{
+		service: service,
+		conf:    conf.withDefaults(),
+		rand:    rand.Float64,
+	}
+}
+
+// 取当前时间所在的桶，过期的桶会被清空，调用方需持有锁
+func (t *throttler) bucket(now time.Time) *throttleBucket {
+	width := t.conf.Window / throttleBuckets
+	start := now.Truncate(width)
+	b := &t.buckets[int(start.UnixNano()/int64(width))%throttleBuckets]
+	if !b.start.Equal(start) {
+		*b = throttleBucket{start: start}
+	}
+	return b
+}
+
+func (t *throttler) counts(now time.Time) (requests, accepts int) {
+	for _, b := range t.buckets {
+		if now.Sub(b.start) < t.conf.Window {
+			requests += b.requests
+			accepts += b.accepts
+		}
+	}
+	return requests, accepts
+}
+
+// 按拒绝概率决定是否在本地拒绝，被拒绝的请求同样计入请求数
+func (t *throttler) allow(now time.Time) bool {
+	t.Lock()
+	defer t.Unlock()
+	requests, accepts := t.counts(now)
+	t.bucket(now).requests++
+	p := (float64(requests) - t.conf.K*float64(accepts)) / float64(requests+1)
+	return p <= 0 || t.rand() >= p
+}
+
+func (t *throttler) accept(now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	t.bucket(now).accepts++
+}
+
+// 没有到达服务端的请求不计入请求数
+func (t *throttler) forget(now time.Time) {
+	t.Lock()
+	defer t.Unlock()
+	if b := t.bucket(now); b.requests > 0 {
+		b.requests--
+	}
+}
+
+// 服务端因过载拒绝的请求不计入accepts
+func isBackendRejection(err error) bool {
+	switch status.Code(err) {
+	case codes.ResourceExhausted, codes.Unavailable:
+		return true
+	}
+	return false
+}
+
+// 本地拒绝的请求没有到达服务端
+func isLocalRejection(err error) bool {
+	return IsLocalThrottled(err) || IsBulkheadFull(err)
+}
+
+func (t *throttler) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if !t.allow(time.Now()) {
+		return status.Errorf(CodeLocalThrottled, "%v%v throttled locally", t.service, method)
+	}
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	switch {
+	case isLocalRejection(err):
+		t.forget(time.Now())
+	case !isBackendRejection(err):
+		t.accept(time.Now())
+	}
+	return err
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+func TestThrottlerRejectProbability(t *testing.T) {
+	th := newThrottler("test", ThrottleConfig{K: 2})
+	th.rand = func() float64 { return 0.5 }
+	now := time.Now()
+
+	// 后端全部接受时不会本地拒绝
+	for i := 0; i < 100; i++ {
+		if !th.allow(now) {
+			t.Fatalf("request %v should be allowed", i)
+		}
+		th.accept(now)
+	}
+
+	// 后端持续拒绝，requests - 2*accepts 超过一半请求数后开始本地拒绝
+	rejected := false
+	for i := 0; i < 1000 && !rejected; i++ {
+		rejected = !th.allow(now)
+	}
+	if !rejected {
+		t.Fatalf("requests should be throttled when backend keeps rejecting")
+	}
+
+	// 统计窗口过期后恢复
+	if !th.allow(now.Add(3 * time.Minute)) {
+		t.Fatalf("request should be allowed after window expires")
+	}
+}
+
+func TestThrottlerIgnoreLocalRejection(t *testing.T) {
+	th := newThrottler("test", ThrottleConfig{K: 1})
+	th.rand = func() float64 { return 0 }
+	full := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return status.Errorf(CodeBulkheadFull, "bulkhead full")
+	}
+	for i := 0; i < 100; i++ {
+		if err := th.unaryInterceptor(context.Background(), "/m", nil, nil, nil, full); !IsBulkheadFull(err) {
+			t.Fatalf("request %v should reach invoker, err= %v", i, err)
+		}
+	}
+	if requests, accepts := th.counts(time.Now()); requests != 0 || accepts != 0 {
+		t.Fatalf("requests= %v, accepts= %v, local rejections should not be counted", requests, accepts)
+	}
+}
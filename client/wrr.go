@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+	"sync"
+)
+
+// 平滑加权轮询负载均衡的名称，权重取自AddressMeta.Weight
+const WeightedRoundRobin = "pika_weighted_round_robin"
+
+func newWeightedRoundRobinBuilder() balancer.Builder {
+	return newBalancerBuilder(WeightedRoundRobin, &wrrPickerBuilder{})
+}
+
+type wrrPickerBuilder struct{}
+
+func (*wrrPickerBuilder) Build(readySCs map[resolver.Address]balancer.SubConn) balancer.Picker {
+	p := &wrrPicker{}
+	for addr, sc := range readySCs {
+		p.items = append(p.items, &wrrItem{
+			subConn: sc,
+			weight:  addressMeta(addr).Weight,
+		})
+	}
+	return p
+}
+
+type wrrItem struct {
+	subConn balancer.SubConn
+	weight  int
+	current int
+}
+
+// 参考nginx的平滑加权轮询，每次选择current最大的实例，避免高权重实例被连续选中
+type wrrPicker struct {
+	sync.Mutex
+	items []*wrrItem
+}
+
+func (p *wrrPicker) Pick(ctx context.Context, opts balancer.PickOptions) (balancer.SubConn, func(balancer.DoneInfo), error) {
+	if len(p.items) == 0 {
+		return nil, nil, balancer.ErrNoSubConnAvailable
+	}
+	p.Lock()
+	defer p.Unlock()
+	total := 0
+	var best *wrrItem
+	for _, item := range p.items {
+		item.current += item.weight
+		total += item.weight
+		if best == nil || item.current > best.current {
+			best = item
+		}
+	}
+	best.current -= total
+	return best.subConn, nil, nil
+}
@@ -0,0 +1,47 @@
+package client
+
+import (
+	"context"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/resolver"
+	"testing"
+)
+
+type fakeSubConn struct {
+	addr string
+}
+
+func (sc *fakeSubConn) UpdateAddresses([]resolver.Address) {}
+
+func (sc *fakeSubConn) Connect() {}
+
+func TestWeightedRoundRobinPicker(t *testing.T) {
+	readySCs := map[resolver.Address]balancer.SubConn{
+		{Addr: "a", Metadata: AddressMeta{Weight: 5}}: &fakeSubConn{addr: "a"},
+		{Addr: "b", Metadata: AddressMeta{Weight: 1}}: &fakeSubConn{addr: "b"},
+		{Addr: "c", Metadata: AddressMeta{Weight: 1}}: &fakeSubConn{addr: "c"},
+	}
+	picker := (&wrrPickerBuilder{}).Build(readySCs)
+
+	counts := make(map[string]int)
+	last, repeated := "", 0
+	for i := 0; i < 70; i++ {
+		sc, _, err := picker.Pick(context.Background(), balancer.PickOptions{})
+		if err != nil {
+			t.Fatalf("pick failed, err= %v", err)
+		}
+		addr := sc.(*fakeSubConn).addr
+		counts[addr]++
+		if addr == last {
+			repeated++
+		} else {
+			last, repeated = addr, 1
+		}
+		if repeated > 4 {
+			t.Fatalf("%v picked %v times in a row, picks should be smooth", addr, repeated)
+		}
+	}
+	if counts["a"] != 50 || counts["b"] != 10 || counts["c"] != 10 {
+		t.Fatalf("counts= %v, want a:50 b:10 c:10", counts)
+	}
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"google.golang.org/grpc/resolver"
+	"os"
+)
+
+// 未配置可用区时从该环境变量读取
+const ZoneEnv = "PIKA_ZONE"
+
+// ZoneConfig 可用区就近路由配置
+type ZoneConfig struct {
+	Zone string // 客户端所在可用区，默认取环境变量PIKA_ZONE
+	LocalityConfig
+}
+
+func (c ZoneConfig) withDefaults() ZoneConfig {
+	if c.Zone == "" {
+		c.Zone = os.Getenv(ZoneEnv)
+	}
+	return c
+}
+
+// 其他可用区的实例排在本可用区所有层级之后，未声明可用区的实例视为其他可用区
+func zoneTiers(addresses []resolver.Address, zone string) {
+	maxTier := 0
+	for _, addr := range addresses {
+		if tier := addressMeta(addr).Tier; tier > maxTier {
+			maxTier = tier
+		}
+	}
+	for i := range addresses {
+		meta := addressMeta(addresses[i])
+		if meta.Zone != zone {
+			meta.Tier += maxTier + 1
+		}
+		addresses[i].Metadata = meta
+	}
+}
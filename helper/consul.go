@@ -0,0 +1,141 @@
+package helper
+
+import (
+	"fmt"
+	"github.com/hashicorp/consul/api"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// consul agent的默认端口，未配置地址时连接本机IP:8500
+	ConsulPort = "8500"
+	// 存放ACL token的文件路径，与CONSUL_HTTP_TOKEN同时设置时以CONSUL_HTTP_TOKEN为准
+	ConsulTokenFileEnv = "CONSUL_HTTP_TOKEN_FILE"
+)
+
+// ConsulConfig consul连接配置，对应service_info.yml中的Consul段
+// 设置了CONSUL_HTTP_ADDR、CONSUL_HTTP_TOKEN、CONSUL_HTTP_TOKEN_FILE、CONSUL_HTTP_SSL、CONSUL_CACERT等环境变量时，以环境变量为准
+type ConsulConfig struct {
+	Address            string        `yaml:"Address"`            // 默认为本机IP:8500
+	Scheme             string        `yaml:"Scheme"`             // http或https
+	Token              string        `yaml:"Token"`              // ACL token
+	TokenFile          string        `yaml:"TokenFile"`          // 存放ACL token的文件，Token为空时使用
+	Datacenter         string        `yaml:"Datacenter"`         // 为空时使用agent所在的数据中心
+	Prefix             string        `yaml:"Prefix"`             // 所有KV key的公共前缀，用于多个团队共用一个consul集群
+	CAFile             string        `yaml:"CAFile"`             // 校验consul证书的CA
+	CertFile           string        `yaml:"CertFile"`           // 客户端证书
+	KeyFile            string        `yaml:"KeyFile"`            // 客户端私钥
+	InsecureSkipVerify bool          `yaml:"InsecureSkipVerify"` // 不校验consul证书
+	DialTimeout        time.Duration `yaml:"DialTimeout"`        // 建立连接的超时，默认5s
+	WaitTime           time.Duration `yaml:"WaitTime"`           // blocking query的最长等待时间，默认5分钟
+}
+
+var (
+	defaultConsulMu     sync.RWMutex
+	defaultConsulConfig ConsulConfig
+)
+
+// DefaultConsulConfig 未单独指定时使用的consul配置，server加载配置后设置为ServiceConf.Consul
+func DefaultConsulConfig() ConsulConfig {
+	defaultConsulMu.RLock()
+	defer defaultConsulMu.RUnlock()
+	return defaultConsulConfig
+}
+
+// SetDefaultConsulConfig 设置未单独指定时使用的consul配置
+func SetDefaultConsulConfig(conf ConsulConfig) {
+	defaultConsulMu.Lock()
+	defer defaultConsulMu.Unlock()
+	defaultConsulConfig = conf
+}
+
+func envSet(name string) bool {
+	_, ok := os.LookupEnv(name)
+	return ok
+}
+
+// 环境变量优先：依次取CONSUL_HTTP_TOKEN_FILE、Token、TokenFile，CONSUL_HTTP_TOKEN由APIConfig处理
+func (c ConsulConfig) token() (string, error) {
+	file := os.Getenv(ConsulTokenFileEnv)
+	if file == "" {
+		if c.Token != "" {
+			return c.Token, nil
+		}
+		file = c.TokenFile
+	}
+	if file == "" {
+		return "", nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("read consul token file failed, err= %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// APIConfig 转换为consul api的配置
+func (c ConsulConfig) APIConfig() (*api.Config, error) {
+	config := api.DefaultConfig() // 已读取CONSUL_HTTP_*环境变量
+	if !envSet(api.HTTPAddrEnvName) {
+		config.Address = c.Address
+		if config.Address == "" {
+			config.Address = GetLocalAddress(ConsulPort)
+		}
+	}
+	if !envSet(api.HTTPSSLEnvName) && c.Scheme != "" {
+		config.Scheme = c.Scheme
+	}
+	if !envSet(api.HTTPTokenEnvName) {
+		token, err := c.token()
+		if err != nil {
+			return nil, err
+		}
+		config.Token = token
+	}
+	if !envSet(api.HTTPCAFile) && c.CAFile != "" {
+		config.TLSConfig.CAFile = c.CAFile
+	}
+	if !envSet(api.HTTPClientCert) && c.CertFile != "" {
+		config.TLSConfig.CertFile = c.CertFile
+	}
+	if !envSet(api.HTTPClientKey) && c.KeyFile != "" {
+		config.TLSConfig.KeyFile = c.KeyFile
+	}
+	if !envSet(api.HTTPSSLVerifyEnvName) && c.InsecureSkipVerify {
+		config.TLSConfig.InsecureSkipVerify = true
+	}
+	config.Datacenter = c.Datacenter
+
+	dialTimeout := c.DialTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = 5 * time.Second
+	}
+	config.Transport.DialContext = (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	config.WaitTime = c.WaitTime
+	if config.WaitTime <= 0 {
+		config.WaitTime = 5 * time.Minute
+	}
+	return config, nil
+}
+
+// NewClient 按配置创建consul客户端
+func (c ConsulConfig) NewClient() (*api.Client, error) {
+	config, err := c.APIConfig()
+	if err != nil {
+		return nil, err
+	}
+	return api.NewClient(config)
+}
+
+// Key 为KV key加上公共前缀
+func (c ConsulConfig) Key(key string) string {
+	if c.Prefix == "" {
+		return key
+	}
+	return strings.TrimSuffix(c.Prefix, "/") + "/" + strings.TrimPrefix(key, "/")
+}
@@ -0,0 +1,91 @@
+package helper_test
+
+import (
+	"github.com/Carey6918/PikaRPC/helper"
+	"github.com/hashicorp/consul/api"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestConsulConfig(t *testing.T) {
+	os.Unsetenv(api.HTTPAddrEnvName)
+	os.Unsetenv(api.HTTPTokenEnvName)
+	file, err := ioutil.TempFile("", "consul-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("secret\n")
+	file.Close()
+
+	conf := helper.ConsulConfig{Address: "consul.local:8500", TokenFile: file.Name(), Datacenter: "dc2"}
+	config, err := conf.APIConfig()
+	if err != nil {
+		t.Fatalf("api config failed, err= %v", err)
+	}
+	if config.Address != "consul.local:8500" || config.Token != "secret" || config.Datacenter != "dc2" {
+		t.Fatalf("config= %+v", config)
+	}
+
+	if _, err := (helper.ConsulConfig{TokenFile: "/nonexistent"}).APIConfig(); err == nil {
+		t.Fatalf("missing token file should fail")
+	}
+
+	// 环境变量优先于配置文件
+	os.Setenv(api.HTTPAddrEnvName, "10.0.0.1:8500")
+	os.Setenv(api.HTTPTokenEnvName, "from-env")
+	defer os.Unsetenv(api.HTTPAddrEnvName)
+	defer os.Unsetenv(api.HTTPTokenEnvName)
+	config, err = conf.APIConfig()
+	if err != nil {
+		t.Fatalf("api config failed, err= %v", err)
+	}
+	if config.Address != "10.0.0.1:8500" || config.Token != "from-env" {
+		t.Fatalf("config= %+v, want values from env", config)
+	}
+}
+
+// token的优先级：CONSUL_HTTP_TOKEN > CONSUL_HTTP_TOKEN_FILE > Token > TokenFile
+func TestConsulTokenPrecedence(t *testing.T) {
+	os.Unsetenv(api.HTTPTokenEnvName)
+	os.Unsetenv(helper.ConsulTokenFileEnv)
+	file, err := ioutil.TempFile("", "consul-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("from-env-file\n")
+	file.Close()
+
+	token := func(conf helper.ConsulConfig) string {
+		config, err := conf.APIConfig()
+		if err != nil {
+			t.Fatalf("api config failed, err= %v", err)
+		}
+		return config.Token
+	}
+	conf := helper.ConsulConfig{Address: "consul.local:8500", Token: "from-config", TokenFile: "/nonexistent"}
+	if got := token(conf); got != "from-config" {
+		t.Fatalf("token= %v, want Token over TokenFile", got)
+	}
+	os.Setenv(helper.ConsulTokenFileEnv, file.Name())
+	defer os.Unsetenv(helper.ConsulTokenFileEnv)
+	if got := token(conf); got != "from-env-file" {
+		t.Fatalf("token= %v, want %v over Token", got, helper.ConsulTokenFileEnv)
+	}
+	os.Setenv(api.HTTPTokenEnvName, "from-env")
+	defer os.Unsetenv(api.HTTPTokenEnvName)
+	if got := token(conf); got != "from-env" {
+		t.Fatalf("token= %v, want %v first", got, api.HTTPTokenEnvName)
+	}
+}
+
+func TestConsulConfigKey(t *testing.T) {
+	if got := (helper.ConsulConfig{}).Key("pika/config/add/"); got != "pika/config/add/" {
+		t.Fatalf("key= %v", got)
+	}
+	if got := (helper.ConsulConfig{Prefix: "team-a/"}).Key("/pika/config/add/"); got != "team-a/pika/config/add/" {
+		t.Fatalf("key= %v", got)
+	}
+}
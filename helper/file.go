@@ -0,0 +1,32 @@
+package helper
+
+import (
+	"code.byted.org/gopkg/pkg/log"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// WatchFile 周期性检查本地文件，修改时间变化时以文件内容回调fn，done关闭后返回
+func WatchFile(file string, interval time.Duration, done <-chan struct{}, fn func(data []byte)) {
+	var modTime time.Time
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if info, err := os.Stat(file); err != nil {
+			log.Warnf("stat file %v failed, err= %v", file, err)
+		} else if !info.ModTime().Equal(modTime) {
+			if data, err := ioutil.ReadFile(file); err != nil {
+				log.Warnf("read file %v failed, err= %v", file, err)
+			} else {
+				modTime = info.ModTime()
+				fn(data)
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
@@ -3,24 +3,185 @@ package helper
 import (
 	"fmt"
 	"net"
+	"path/filepath"
+	"sync"
 )
 
-func GetLocalIP() string {
-	addresses, err := net.InterfaceAddrs()
+// 本机IP的地址族
+const (
+	IPv4  = "ipv4"
+	IPv6  = "ipv6"
+	IPAny = "any" // IPv4和IPv6都可以，默认优先IPv4
+)
+
+// IPConfig 本机IP的选择规则，对应service_info.yml中的IP段
+// 按网卡序号、网卡上地址的顺序选择第一个满足条件的地址，跳过回环地址和IPv6链路本地地址
+type IPConfig struct {
+	Interfaces []string `yaml:"Interfaces"` // 网卡名的匹配模式，如eth*、en0，为空时不限制；显式匹配时可以选择回环网卡
+	Allow      []string `yaml:"Allow"`      // 允许的网段，如10.0.0.0/8，为空时不限制
+	Deny       []string `yaml:"Deny"`       // 排除的网段，如docker网桥172.17.0.0/16，优先于Allow
+	Family     string   `yaml:"Family"`     // ipv4、ipv6或any，默认ipv4
+	PreferIPv6 bool     `yaml:"PreferIPv6"` // Family为any时优先选择IPv6地址
+}
+
+var (
+	defaultIPMu     sync.RWMutex
+	defaultIPConfig IPConfig
+)
+
+// DefaultIPConfig GetLocalIP使用的选择规则，server加载配置后设置为ServiceConf.IP
+func DefaultIPConfig() IPConfig {
+	defaultIPMu.RLock()
+	defer defaultIPMu.RUnlock()
+	return defaultIPConfig
+}
+
+// SetDefaultIPConfig 设置GetLocalIP使用的选择规则
+func SetDefaultIPConfig(conf IPConfig) {
+	defaultIPMu.Lock()
+	defer defaultIPMu.Unlock()
+	defaultIPConfig = conf
+}
+
+// Validate 校验网段和地址族
+func (c IPConfig) Validate() error {
+	switch c.Family {
+	case "", IPv4, IPv6, IPAny:
+	default:
+		return fmt.Errorf("unknown ip family %q", c.Family)
+	}
+	for _, pattern := range c.Interfaces {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad interface pattern %q", pattern)
+		}
+	}
+	if _, err := parseCIDRs(c.Allow); err != nil {
+		return err
+	}
+	if _, err := parseCIDRs(c.Deny); err != nil {
+		return err
+	}
+	return nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("bad cidr %q", cidr)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, ipNet := range nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 网卡名是否匹配Interfaces，未配置时都匹配
+func (c IPConfig) matchInterface(name string) bool {
+	if len(c.Interfaces) == 0 {
+		return true
+	}
+	for _, pattern := range c.Interfaces {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Select 按规则选择本机IP
+func (c IPConfig) Select() (string, error) {
+	allow, err := parseCIDRs(c.Allow)
 	if err != nil {
-		return ""
+		return "", err
 	}
-	for _, address := range addresses {
-		// 检查ip地址判断是否回环地址
-		if ipNet, ok := address.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
-			if ipNet.IP.To4() != nil {
-				return ipNet.IP.String()
+	deny, err := parseCIDRs(c.Deny)
+	if err != nil {
+		return "", err
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	var v4, v6 net.IP
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || !c.matchInterface(iface.Name) {
+			continue
+		}
+		addresses, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			ipNet, ok := address.(*net.IPNet)
+			if !ok || ipNet.IP.IsLinkLocalUnicast() {
+				continue
+			}
+			ip := ipNet.IP
+			if ip.IsLoopback() && len(c.Interfaces) == 0 {
+				continue
+			}
+			if containsIP(deny, ip) || (len(allow) > 0 && !containsIP(allow, ip)) {
+				continue
+			}
+			if ip.To4() != nil {
+				if v4 == nil {
+					v4 = ip
+				}
+			} else if v6 == nil {
+				v6 = ip
 			}
 		}
 	}
-	return ""
+
+	candidates := []net.IP{v4}
+	switch c.Family {
+	case IPv6:
+		candidates = []net.IP{v6}
+	case IPAny:
+		candidates = []net.IP{v4, v6}
+		if c.PreferIPv6 {
+			candidates = []net.IP{v6, v4}
+		}
+	}
+	for _, ip := range candidates {
+		if ip != nil {
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no local ip matches %+v", c)
+}
+
+// LocalIP 按DefaultIPConfig选择本机IP
+func LocalIP() (string, error) {
+	return DefaultIPConfig().Select()
 }
 
-func GetLocalAddress(port string) string{
-	return fmt.Sprintf("%s:%s",GetLocalIP(),port)
+// GetLocalIP 按DefaultIPConfig选择本机IP，没有满足条件的地址时返回空
+func GetLocalIP() string {
+	ip, _ := LocalIP()
+	return ip
+}
+
+func GetLocalAddress(port string) string {
+	return net.JoinHostPort(GetLocalIP(), port)
+}
+
+// GetInterfaceIP 按DefaultIPConfig的网段和地址族选择指定网卡上的地址
+func GetInterfaceIP(name string) (string, error) {
+	if _, err := net.InterfaceByName(name); err != nil {
+		return "", err
+	}
+	c := DefaultIPConfig()
+	c.Interfaces = []string{name}
+	return c.Select()
 }
@@ -9,3 +9,59 @@ func TestGetLocalAddress(t *testing.T) {
 	addr := helper.GetLocalAddress("9785")
 	t.Logf("GetLocalAddress, address= %v", addr)
 }
+
+func TestIPConfigSelect(t *testing.T) {
+	// 未显式匹配时跳过回环地址
+	if ip, err := (helper.IPConfig{Interfaces: []string{"l*"}}).Select(); err != nil || ip != "127.0.0.1" {
+		t.Fatalf("ip= %v, err= %v, want 127.0.0.1", ip, err)
+	}
+	if ip, err := (helper.IPConfig{Allow: []string{"127.0.0.0/8"}}).Select(); err == nil {
+		t.Fatalf("ip= %v, loopback should be skipped", ip)
+	}
+	if ip, err := (helper.IPConfig{Interfaces: []string{"lo"}, Deny: []string{"127.0.0.0/8"}}).Select(); err == nil {
+		t.Fatalf("ip= %v, denied cidr should be skipped", ip)
+	}
+	if ip, err := (helper.IPConfig{Interfaces: []string{"lo"}, Allow: []string{"10.0.0.0/8"}}).Select(); err == nil {
+		t.Fatalf("ip= %v, ip not in allowed cidr should be skipped", ip)
+	}
+
+	ip, err := (helper.IPConfig{Interfaces: []string{"lo"}, Family: helper.IPAny, PreferIPv6: true}).Select()
+	if err != nil {
+		t.Fatalf("err= %v", err)
+	}
+	if _, err := (helper.IPConfig{Interfaces: []string{"lo"}, Family: helper.IPv6}).Select(); err != nil {
+		t.Logf("ipv6 disabled on lo, ip= %v", ip)
+	} else if ip != "::1" {
+		t.Fatalf("ip= %v, want ::1 when prefer ipv6", ip)
+	}
+}
+
+func TestIPConfigValidate(t *testing.T) {
+	if err := (helper.IPConfig{Interfaces: []string{"eth*"}, Allow: []string{"10.0.0.0/8"}, Family: helper.IPAny}).Validate(); err != nil {
+		t.Fatalf("err= %v", err)
+	}
+	for _, c := range []helper.IPConfig{{Family: "ipx"}, {Allow: []string{"10.0.0.0"}}, {Deny: []string{"bad"}}, {Interfaces: []string{"eth["}}} {
+		if err := c.Validate(); err == nil {
+			t.Fatalf("config= %+v should be invalid", c)
+		}
+	}
+}
+
+// 运行中替换默认规则时，读取方不会读到中间状态
+func TestDefaultIPConfig(t *testing.T) {
+	defer helper.SetDefaultIPConfig(helper.DefaultIPConfig())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			helper.SetDefaultIPConfig(helper.IPConfig{Interfaces: []string{"lo*"}})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		helper.GetLocalIP()
+	}
+	<-done
+	if ip, err := helper.LocalIP(); err != nil || ip != "127.0.0.1" {
+		t.Fatalf("ip= %v, err= %v, want loopback from default config", ip, err)
+	}
+}
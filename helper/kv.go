@@ -0,0 +1,67 @@
+package helper
+
+import (
+	"code.byted.org/gopkg/pkg/log"
+	"github.com/hashicorp/consul/api"
+	"time"
+)
+
+const (
+	kvMinBackoff = time.Second
+	kvMaxBackoff = 30 * time.Second
+)
+
+// WatchKV 通过blocking query监听consul KV，key的值(或是否存在)变化时回调fn，key不存在时pair为nil
+// 每次等待的时长取client配置的WaitTime，请求失败时按指数退避重试，done关闭后返回
+func WatchKV(client *api.Client, key string, done <-chan struct{}, fn func(pair *api.KVPair)) {
+	watchIndex(key, 0, done, func(q *api.QueryOptions) (func(), *api.QueryMeta, error) {
+		pair, meta, err := client.KV().Get(key, q)
+		return func() { fn(pair) }, meta, err
+	})
+}
+
+// WatchKVPrefix 与WatchKV相同，监听prefix下的所有key，任一key变化时回调fn
+// index为调用方已读取到的LastIndex，从该index之后的变化开始回调，为0时先回调一次当前值
+func WatchKVPrefix(client *api.Client, prefix string, index uint64, done <-chan struct{}, fn func(pairs api.KVPairs)) {
+	watchIndex(prefix, index, done, func(q *api.QueryOptions) (func(), *api.QueryMeta, error) {
+		pairs, meta, err := client.KV().List(prefix, q)
+		return func() { fn(pairs) }, meta, err
+	})
+}
+
+// blocking query的通用循环，query返回的回调只在index变化时执行
+func watchIndex(name string, index uint64, done <-chan struct{}, query func(q *api.QueryOptions) (func(), *api.QueryMeta, error)) {
+	backoff := kvMinBackoff
+	first := index == 0
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		notify, meta, err := query(&api.QueryOptions{WaitIndex: index})
+		if err != nil {
+			log.Warnf("watch consul kv %v failed, retry after %v, err= %v", name, backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-done:
+				return
+			}
+			if backoff *= 2; backoff > kvMaxBackoff {
+				backoff = kvMaxBackoff
+			}
+			continue
+		}
+		backoff = kvMinBackoff
+		// index回退说明consul状态被重置，需要重新开始监听
+		if meta.LastIndex < index {
+			index = 0
+			continue
+		}
+		if first || meta.LastIndex != index {
+			notify()
+			first = false
+		}
+		index = meta.LastIndex
+	}
+}
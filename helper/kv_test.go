@@ -0,0 +1,43 @@
+package helper_test
+
+import (
+	"github.com/Carey6918/PikaRPC/helper"
+	"github.com/hashicorp/consul/api"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 从调用方已读取的index开始监听时，不会再回调一次相同的配置
+func TestWatchKVPrefixIndex(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("index") == "5" {
+			time.Sleep(5 * time.Millisecond)
+		}
+		w.Header().Set("X-Consul-Index", "5")
+		w.Write([]byte(`[{"Key": "pika/config/add/Level", "Value": "ZGVidWc="}]`))
+	}))
+	defer srv.Close()
+	client, err := api.NewClient(&api.Config{Address: srv.Listener.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	watch := func(index uint64) int32 {
+		var calls int32
+		done := make(chan struct{})
+		go helper.WatchKVPrefix(client, "pika/config/add/", index, done, func(pairs api.KVPairs) {
+			atomic.AddInt32(&calls, 1)
+		})
+		time.Sleep(50 * time.Millisecond)
+		close(done)
+		return atomic.LoadInt32(&calls)
+	}
+	if calls := watch(0); calls != 1 {
+		t.Fatalf("calls= %v, want current value once", calls)
+	}
+	if calls := watch(5); calls != 0 {
+		t.Fatalf("calls= %v, want no callback for the index already read", calls)
+	}
+}
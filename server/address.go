@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Carey6918/PikaRPC/helper"
+	"net"
+	"os"
+)
+
+// 监听地址，默认为按IP段选择的本机IP
+func bindAddress() (string, error) {
+	if conf := GetServiceConf(); conf.BindAddress != "" {
+		return conf.BindAddress, nil
+	}
+	return helper.LocalIP()
+}
+
+// 注册到consul的地址，依次取AdvertiseAddress、AdvertiseAddressEnv、AdvertiseInterface，
+// 都未设置时取监听地址，监听所有地址(如0.0.0.0)时取按IP段选择的本机IP
+func advertiseAddress() (string, error) {
+	conf := GetServiceConf()
+	if conf.AdvertiseAddress != "" {
+		return conf.AdvertiseAddress, nil
+	}
+	if env := conf.AdvertiseAddressEnv; env != "" {
+		address := os.Getenv(env)
+		if address == "" {
+			return "", fmt.Errorf("advertise address env %v is empty", env)
+		}
+		return address, nil
+	}
+	if conf.AdvertiseInterface != "" {
+		return helper.GetInterfaceIP(conf.AdvertiseInterface)
+	}
+	if ip := net.ParseIP(conf.BindAddress); ip != nil && !ip.IsUnspecified() {
+		return conf.BindAddress, nil
+	}
+	return helper.LocalIP()
+}
+
+// 注册到consul的地址和端口，开始监听后端口为实际监听的端口(ServicePort为0时由系统分配)
+func advertised() (string, int) {
+	if GServer != nil && GServer.listener != nil {
+		return GServer.advertise, GServer.port
+	}
+	address, err := advertiseAddress()
+	if err != nil {
+		address = helper.GetLocalIP()
+	}
+	return address, helper.S2I(GetServiceConf().ServicePort)
+}
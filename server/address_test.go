@@ -0,0 +1,86 @@
+package server
+
+import (
+	"github.com/Carey6918/PikaRPC/helper"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestAdvertiseAddress(t *testing.T) {
+	old := ServiceConf
+	defer func() { ServiceConf = old }()
+
+	ServiceConf = ServiceConfig{BindAddress: "127.0.0.1"}
+	if address, err := advertiseAddress(); err != nil || address != "127.0.0.1" {
+		t.Fatalf("address= %v, err= %v, want bind address", address, err)
+	}
+	// 监听所有地址时注册本机IP
+	ServiceConf.BindAddress = "0.0.0.0"
+	if address, err := advertiseAddress(); err != nil || address == "0.0.0.0" {
+		t.Fatalf("address= %v, err= %v, want local ip", address, err)
+	}
+
+	ServiceConf.AdvertiseAddressEnv = "PIKA_TEST_HOST_IP"
+	if _, err := advertiseAddress(); err == nil {
+		t.Fatalf("empty advertise env should fail")
+	}
+	os.Setenv("PIKA_TEST_HOST_IP", "10.0.0.2")
+	defer os.Unsetenv("PIKA_TEST_HOST_IP")
+	if address, err := advertiseAddress(); err != nil || address != "10.0.0.2" {
+		t.Fatalf("address= %v, err= %v, want env", address, err)
+	}
+
+	ServiceConf.AdvertiseAddress = "10.0.0.1"
+	if address, err := advertiseAddress(); err != nil || address != "10.0.0.1" {
+		t.Fatalf("address= %v, err= %v, want explicit", address, err)
+	}
+
+	ServiceConf = ServiceConfig{AdvertiseInterface: "lo"}
+	if address, err := advertiseAddress(); err != nil || address != "127.0.0.1" {
+		t.Fatalf("address= %v, err= %v, want lo address", address, err)
+	}
+}
+
+// ServicePort为0时注册实际监听的端口
+func TestListenRandomPort(t *testing.T) {
+	oldConf, oldServer := ServiceConf, GServer
+	defer func() { ServiceConf, GServer = oldConf, oldServer }()
+	ServiceConf = ServiceConfig{ServiceName: "add", ServicePort: "0", BindAddress: "127.0.0.1"}
+
+	NewServer()
+	if err := GServer.listen(); err != nil {
+		t.Fatalf("listen failed, err= %v", err)
+	}
+	defer GServer.listener.Close()
+	reg := NewRegisterContest().registration()
+	if reg.Port == 0 || reg.Port != GServer.port || reg.Address != "127.0.0.1" {
+		t.Fatalf("registration= %v:%v, listening= %v", reg.Address, reg.Port, GServer.listener.Addr())
+	}
+}
+
+// 未设置AdminToken时管理接口只允许本机访问，默认的监听地址为本机IP，需要额外监听回环地址
+func TestAdminAddresses(t *testing.T) {
+	old := ServiceConf
+	defer func() { ServiceConf = old }()
+	ServiceConf = ServiceConfig{AdminPort: "9786"}
+	if local, err := helper.LocalIP(); err == nil && !net.ParseIP(local).IsLoopback() {
+		addrs, err := adminAddresses()
+		if err != nil || len(addrs) != 2 || addrs[0] != net.JoinHostPort(local, "9786") || addrs[1] != "127.0.0.1:9786" {
+			t.Fatalf("addrs= %v, err= %v, want default bind and loopback", addrs, err)
+		}
+	}
+
+	ServiceConf.BindAddress = "10.0.0.1"
+	if addrs, _ := adminAddresses(); len(addrs) != 2 || addrs[1] != "127.0.0.1:9786" {
+		t.Fatalf("addrs= %v, want loopback added", addrs)
+	}
+	ServiceConf.BindAddress = "0.0.0.0"
+	if addrs, _ := adminAddresses(); len(addrs) != 1 {
+		t.Fatalf("addrs= %v, want all addresses only", addrs)
+	}
+	ServiceConf.BindAddress, ServiceConf.AdminToken = "10.0.0.1", "secret"
+	if addrs, _ := adminAddresses(); len(addrs) != 1 || addrs[0] != "10.0.0.1:9786" {
+		t.Fatalf("addrs= %v, want bind address only with token", addrs)
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"code.byted.org/gopkg/pkg/log"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// AdminMux 管理接口的路由，ServiceConf.AdminPort非空时在该端口提供服务
+var AdminMux = http.NewServeMux()
+
+func init() {
+	AdminMux.HandleFunc("/features", authorized(featuresHandler))
+	AdminMux.HandleFunc("/config/restart", authorized(restartHandler))
+}
+
+// 管理接口的鉴权，设置了AdminToken时需在Authorization头中携带"Bearer <token>"，否则只允许本机访问
+func authorized(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := GetServiceConf().AdminToken; token != "" {
+			given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		} else if !isLoopback(r.RemoteAddr) {
+			http.Error(w, "forbidden, set AdminToken to allow remote access", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// 管理接口的监听地址，默认为服务的监听地址；
+// 未设置AdminToken时只允许本机访问，监听地址不是回环地址或全部地址时额外监听回环地址
+func adminAddresses() ([]string, error) {
+	bind, err := bindAddress()
+	if err != nil {
+		return nil, err
+	}
+	conf := GetServiceConf()
+	addrs := []string{net.JoinHostPort(bind, conf.AdminPort)}
+	if ip := net.ParseIP(bind); conf.AdminToken == "" && ip != nil && !ip.IsLoopback() && !ip.IsUnspecified() {
+		loopback := "127.0.0.1"
+		if ip.To4() == nil {
+			loopback = "::1"
+		}
+		addrs = append(addrs, net.JoinHostPort(loopback, conf.AdminPort))
+	}
+	return addrs, nil
+}
+
+func serveAdmin() {
+	addrs, err := adminAddresses()
+	if err != nil {
+		log.Errorf("admin server not started, err= %v", err)
+		return
+	}
+	for _, addr := range addrs[1:] {
+		go listenAdmin(addr)
+	}
+	listenAdmin(addrs[0])
+}
+
+func listenAdmin(addr string) {
+	log.Infof("admin server listening on %v", addr)
+	if err := http.ListenAndServe(addr, AdminMux); err != nil {
+		log.Errorf("admin server on %v stopped, err= %v", addr, err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warnf("write admin response failed, err= %v", err)
+	}
+}
+
+// 返回给定上下文下各开关的求值结果
+// 如 /features?caller=order&uid=42 ，caller为调用方服务名，其余参数作为请求属性，flag参数只返回指定开关
+func featuresHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	fc := FeatureContext{
+		Caller:     query.Get("caller"),
+		Attributes: make(map[string]string),
+	}
+	for key, values := range query {
+		if key != "caller" && key != "flag" && len(values) > 0 {
+			fc.Attributes[key] = values[0]
+		}
+	}
+	evals := GFeatures.EvaluateAll(fc)
+	if flag := query.Get("flag"); flag != "" {
+		evals = []Evaluation{GFeatures.Evaluate(flag, fc)}
+	}
+	writeJSON(w, struct {
+		Context     FeatureContext `json:"context"`
+		Evaluations []Evaluation   `json:"evaluations"`
+	}{fc, evals})
+}
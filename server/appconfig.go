@@ -0,0 +1,223 @@
+package server
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	// 运行环境，非空时在service_info.yml之上叠加service_info.<env>.yml
+	EnvironmentEnv = "PIKA_ENV"
+	// 覆盖配置项的环境变量前缀，如PIKA_RATELIMIT_DEFAULTCALLER_QPS覆盖RateLimit.DefaultCaller.QPS
+	ConfigEnvPrefix = "PIKA_"
+)
+
+// Validator 配置结构体可实现此接口做自定义校验，在tag校验之后调用
+type Validator interface {
+	Validate() error
+}
+
+// ConfigErrors 加载配置过程中的全部错误
+type ConfigErrors []error
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d config errors: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// 按顺序返回需要加载的配置文件，环境配置文件不存在时忽略
+func configFiles() []string {
+	files := []string{filepath.Join(ConfigDir, ServiceConfigFile)}
+	if env := os.Getenv(EnvironmentEnv); env != "" {
+		ext := filepath.Ext(ServiceConfigFile)
+		overlay := strings.TrimSuffix(ServiceConfigFile, ext) + "." + env + ext
+		files = append(files, filepath.Join(ConfigDir, overlay))
+	}
+	return files
+}
+
+// LoadConfig 将service_info.yml绑定到out，out须为结构体指针
+// 加载顺序为: default tag -> service_info.yml -> service_info.<env>.yml -> PIKA_环境变量，最后按validate tag和Validator校验
+// 支持的validate tag: required, min=N, max=N，对字符串、slice、map校验长度
+func LoadConfig(out interface{}) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("config must be a pointer to struct, got %T", out)
+	}
+	var errs ConfigErrors
+	walkConfig(v.Elem(), nil, func(field reflect.Value, sf reflect.StructField, path []string) {
+		if def, ok := sf.Tag.Lookup("default"); ok && isZero(field) {
+			if err := setValue(field, def); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid default %q, %v", strings.Join(path, "."), def, err))
+			}
+		}
+	})
+	for i, file := range configFiles() {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			if i > 0 && os.IsNotExist(err) {
+				continue
+			}
+			errs = append(errs, err)
+			continue
+		}
+		// 逐个文件解析到同一结构体，后面的文件只覆盖其中出现的字段
+		if err := yaml.Unmarshal(data, out); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", filepath.Base(file), err))
+		}
+	}
+	walkConfig(v.Elem(), nil, func(field reflect.Value, sf reflect.StructField, path []string) {
+		name := ConfigEnvPrefix + strings.ToUpper(strings.Join(path, "_"))
+		if value, ok := os.LookupEnv(name); ok {
+			if err := setValue(field, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid value %q, %v", name, value, err))
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return errs
+	}
+	return validateConfig(v.Elem())
+}
+
+func validateConfig(v reflect.Value) error {
+	var errs ConfigErrors
+	walkConfig(v, nil, func(field reflect.Value, sf reflect.StructField, path []string) {
+		if rules, ok := sf.Tag.Lookup("validate"); ok {
+			for _, rule := range strings.Split(rules, ",") {
+				if err := checkRule(field, rule); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %v", strings.Join(path, "."), err))
+				}
+			}
+		}
+		if field.Kind() == reflect.Struct {
+			if err := validate(field); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", strings.Join(path, "."), err))
+			}
+		}
+	})
+	if err := validate(v); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validate(v reflect.Value) error {
+	if v.CanAddr() {
+		if validator, ok := v.Addr().Interface().(Validator); ok {
+			return validator.Validate()
+		}
+	}
+	return nil
+}
+
+func checkRule(field reflect.Value, rule string) error {
+	rule = strings.TrimSpace(rule)
+	if rule == "required" {
+		if isZero(field) {
+			return fmt.Errorf("required")
+		}
+		return nil
+	}
+	parts := strings.SplitN(rule, "=", 2)
+	if len(parts) != 2 || (parts[0] != "min" && parts[0] != "max") {
+		return fmt.Errorf("unknown validate rule %q", rule)
+	}
+	limit, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return fmt.Errorf("invalid validate rule %q", rule)
+	}
+	var n float64
+	switch field.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		n = float64(field.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(field.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(field.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = field.Float()
+	default:
+		return fmt.Errorf("rule %q not supported for %v", rule, field.Type())
+	}
+	if parts[0] == "min" && n < limit {
+		return fmt.Errorf("must be >= %v, got %v", parts[1], n)
+	}
+	if parts[0] == "max" && n > limit {
+		return fmt.Errorf("must be <= %v, got %v", parts[1], n)
+	}
+	return nil
+}
+
+// 递归遍历结构体字段，path为字段在YAML中的路径
+func walkConfig(v reflect.Value, path []string, fn func(field reflect.Value, sf reflect.StructField, path []string)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		name, inline := yamlName(sf)
+		if name == "-" {
+			continue
+		}
+		field := v.Field(i)
+		fieldPath := path
+		if !inline {
+			fieldPath = append(append([]string{}, path...), name)
+			fn(field, sf, fieldPath)
+		}
+		if field.Kind() == reflect.Struct {
+			walkConfig(field, fieldPath, fn)
+		}
+	}
+}
+
+// 与yaml.v2一致: 优先取yaml tag，否则为小写的字段名
+func yamlName(sf reflect.StructField) (string, bool) {
+	tag := sf.Tag.Get("yaml")
+	parts := strings.Split(tag, ",")
+	for _, flag := range parts[1:] {
+		if flag == "inline" {
+			return "", true
+		}
+	}
+	if parts[0] != "" {
+		return parts[0], false
+	}
+	return strings.ToLower(sf.Name), false
+}
+
+// 将文本按YAML解析后赋给字段，字符串直接赋值
+func setValue(field reflect.Value, value string) error {
+	if field.Kind() == reflect.String {
+		field.SetString(value)
+		return nil
+	}
+	ptr := reflect.New(field.Type())
+	if err := yaml.Unmarshal([]byte(value), ptr.Interface()); err != nil {
+		return err
+	}
+	field.Set(ptr.Elem())
+	return nil
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	}
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
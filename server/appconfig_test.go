@@ -0,0 +1,139 @@
+package server
+
+import (
+	"fmt"
+	"github.com/Carey6918/PikaRPC/helper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testAppConfig struct {
+	ServiceName string `yaml:"ServiceName" validate:"required"`
+	App         struct {
+		Name    string        `yaml:"Name" validate:"required"`
+		Workers int           `yaml:"Workers" default:"4" validate:"min=1,max=64"`
+		Timeout time.Duration `yaml:"Timeout" default:"1s"`
+		Debug   bool          `yaml:"Debug"`
+		Hosts   []string      `yaml:"Hosts" validate:"min=1"`
+	} `yaml:"App"`
+}
+
+func (c *testAppConfig) Validate() error {
+	if c.App.Debug && c.App.Workers > 1 {
+		return fmt.Errorf("debug mode requires a single worker")
+	}
+	return nil
+}
+
+func writeConfigFiles(t *testing.T, files map[string]string) func() {
+	dir, err := ioutil.TempDir("", "appconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ConfigDir = dir
+	return func() {
+		ConfigDir = ""
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLoadConfigLayering(t *testing.T) {
+	defer writeConfigFiles(t, map[string]string{
+		"service_info.yml": `
+ServiceName: add
+App:
+  Name: base
+  Hosts: [a, b]
+`,
+		"service_info.prod.yml": `
+App:
+  Name: prod
+  Timeout: 3s
+`,
+	})()
+	os.Setenv(EnvironmentEnv, "prod")
+	os.Setenv("PIKA_APP_WORKERS", "8")
+	defer os.Unsetenv(EnvironmentEnv)
+	defer os.Unsetenv("PIKA_APP_WORKERS")
+
+	var conf testAppConfig
+	if err := LoadConfig(&conf); err != nil {
+		t.Fatalf("load failed, err= %v", err)
+	}
+	if conf.ServiceName != "add" || conf.App.Name != "prod" {
+		t.Fatalf("conf= %+v, want prod overlay on base", conf)
+	}
+	if len(conf.App.Hosts) != 2 {
+		t.Fatalf("hosts= %v, want kept from base", conf.App.Hosts)
+	}
+	if conf.App.Timeout != 3*time.Second {
+		t.Fatalf("timeout= %v, want 3s", conf.App.Timeout)
+	}
+	if conf.App.Workers != 8 {
+		t.Fatalf("workers= %v, want 8 from env", conf.App.Workers)
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	defer writeConfigFiles(t, map[string]string{
+		"service_info.yml": "ServiceName: add\nApp: {Name: a, Hosts: [a]}\n",
+	})()
+	// 环境配置文件不存在时忽略
+	os.Setenv(EnvironmentEnv, "staging")
+	defer os.Unsetenv(EnvironmentEnv)
+
+	var conf testAppConfig
+	if err := LoadConfig(&conf); err != nil {
+		t.Fatalf("load failed, err= %v", err)
+	}
+	if conf.App.Workers != 4 || conf.App.Timeout != time.Second {
+		t.Fatalf("conf= %+v, want defaults", conf.App)
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	defer writeConfigFiles(t, map[string]string{
+		"service_info.yml": "App: {Workers: 100, Debug: true}\n",
+	})()
+
+	var conf testAppConfig
+	err := LoadConfig(&conf)
+	errs, ok := err.(ConfigErrors)
+	if !ok {
+		t.Fatalf("err= %v, want ConfigErrors", err)
+	}
+	if len(errs) != 5 {
+		t.Fatalf("errs= %v, want 5", errs)
+	}
+	for _, want := range []string{"ServiceName: required", "App.Name: required", "App.Workers: must be <= 64", "App.Hosts: must be >= 1", "single worker"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("err= %v, want containing %q", err, want)
+		}
+	}
+}
+
+// 嵌套配置的Validate只经walkConfig调用一次，错误不重复
+func TestValidateServiceConfigIPOnce(t *testing.T) {
+	conf := ServiceConfig{IP: helper.IPConfig{Family: "ipv5"}}
+	err := validateConfig(reflect.ValueOf(&conf).Elem())
+	errs, _ := err.(ConfigErrors)
+	count := 0
+	for _, e := range errs {
+		if strings.HasPrefix(e.Error(), "IP: ") {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Fatalf("err= %v, want the IP error reported once", err)
+	}
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"code.byted.org/gopkg/pkg/log"
+	"fmt"
+	"github.com/Carey6918/PikaRPC/helper"
+	consul "github.com/hashicorp/consul/api"
+	"net"
+	"net/http"
+	"time"
+)
+
+// consul健康检查的类型
+const (
+	CheckGRPC = "grpc" // consul通过gRPC健康检查协议访问服务
+	CheckHTTP = "http" // consul访问管理接口的/health
+	CheckTCP  = "tcp"  // consul检查服务端口能否建立连接
+	CheckTTL  = "ttl"  // 服务按健康状态定期上报PassTTL/FailTTL
+)
+
+// CheckConfig 健康检查配置，对应service_info.yml中Checks段的一项
+type CheckConfig struct {
+	Type        string        `yaml:"Type"`        // grpc、http、tcp或ttl
+	Name        string        `yaml:"Name"`        // 检查项名称，同一实例内唯一，默认为Type
+	Interval    time.Duration `yaml:"Interval"`    // grpc/http/tcp检查的间隔，默认10s
+	Timeout     time.Duration `yaml:"Timeout"`     // 单次检查的超时，为0时使用consul的默认值
+	TTL         time.Duration `yaml:"TTL"`         // ttl检查的超时，默认30s，服务每TTL/3上报一次
+	GRPCService string        `yaml:"GRPCService"` // grpc检查的服务名，为空时检查整个服务进程
+	Path        string        `yaml:"Path"`        // http检查的路径，默认/health
+	// 检查持续失败超过这个时间后consul自动注销服务，默认1分钟
+	DeregisterCriticalServiceAfter time.Duration `yaml:"DeregisterCriticalServiceAfter"`
+}
+
+func (c CheckConfig) withDefaults(r *RegisterContext) CheckConfig {
+	if c.Name == "" {
+		c.Name = c.Type
+	}
+	if c.Interval <= 0 {
+		c.Interval = r.Interval
+	}
+	if c.TTL <= 0 {
+		c.TTL = 30 * time.Second
+	}
+	if c.Path == "" {
+		c.Path = "/health"
+	}
+	if c.DeregisterCriticalServiceAfter <= 0 {
+		c.DeregisterCriticalServiceAfter = r.DeregisterCriticalServiceAfter
+	}
+	return c
+}
+
+func checkID(serviceID, name string) string {
+	return fmt.Sprintf("service:%s:%s", serviceID, name)
+}
+
+// 转换为consul的检查项，检查服务注册的地址
+func (c CheckConfig) agentCheck(r *RegisterContext) *consul.AgentServiceCheck {
+	hostPort := net.JoinHostPort(r.Address, helper.I2S(r.Port))
+	check := &consul.AgentServiceCheck{
+		CheckID:                        checkID(r.ServiceName, c.Name),
+		Name:                           c.Name,
+		DeregisterCriticalServiceAfter: c.DeregisterCriticalServiceAfter.String(),
+	}
+	switch c.Type {
+	case CheckGRPC:
+		check.GRPC = hostPort
+		if c.GRPCService != "" {
+			check.GRPC += "/" + c.GRPCService // 作为HealthCheckRequest.Service传给Check
+		}
+	case CheckHTTP:
+		check.HTTP = fmt.Sprintf("http://%v%v", net.JoinHostPort(r.Address, GetServiceConf().AdminPort), c.Path)
+	case CheckTCP:
+		check.TCP = hostPort
+	case CheckTTL:
+		check.TTL = c.TTL.String()
+		return check
+	}
+	check.Interval = c.Interval.String()
+	if c.Timeout > 0 {
+		check.Timeout = c.Timeout.String()
+	}
+	return check
+}
+
+// 校验Checks段
+// ttl检查的最小超时，过小时上报间隔为0
+const minTTL = time.Second
+
+func validateChecks(checks []CheckConfig, adminPort string) error {
+	var errs ConfigErrors
+	names := make(map[string]bool)
+	for i, check := range checks {
+		switch check.Type {
+		case CheckGRPC, CheckTCP:
+		case CheckTTL:
+			if check.TTL != 0 && check.TTL < minTTL {
+				errs = append(errs, fmt.Errorf("Checks[%d]: ttl %v is less than %v", i, check.TTL, minTTL))
+			}
+		case CheckHTTP:
+			if adminPort == "" {
+				errs = append(errs, fmt.Errorf("Checks[%d]: http check requires AdminPort", i))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("Checks[%d]: unknown check type %q", i, check.Type))
+		}
+		name := check.Name
+		if name == "" {
+			name = check.Type
+		}
+		if names[name] {
+			errs = append(errs, fmt.Errorf("Checks[%d]: duplicate check name %q", i, name))
+		}
+		names[name] = true
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+type ttlAgent interface {
+	PassTTL(checkID, note string) error
+	FailTTL(checkID, note string) error
+}
+
+// 为每个ttl检查定期上报健康状态，done关闭后停止
+func startHeartbeats(agent ttlAgent, regs []*consul.AgentServiceRegistration, done <-chan struct{}) {
+	for _, reg := range regs {
+		for _, check := range reg.Checks {
+			if ttl, err := time.ParseDuration(check.TTL); err == nil && ttl >= minTTL {
+				go heartbeat(agent, check.CheckID, ttl/3, done)
+			}
+		}
+	}
+}
+
+func heartbeat(agent ttlAgent, checkID string, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := reportTTL(agent, checkID); err != nil {
+			// agent重启后检查项丢失，触发重新注册
+			log.Warnf("report ttl check %v failed, err= %v", checkID, err)
+			if GRegistrar != nil {
+				GRegistrar.Sync()
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+func reportTTL(agent ttlAgent, checkID string) error {
+	if GHealthServer.Serving() {
+		return agent.PassTTL(checkID, "serving")
+	}
+	return agent.FailTTL(checkID, "not serving")
+}
+
+func init() {
+	AdminMux.HandleFunc("/health", healthHandler)
+}
+
+// http检查访问的管理接口，健康时返回200，否则返回503
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	if !GHealthServer.Serving() {
+		http.Error(w, "NOT_SERVING", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("SERVING"))
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"context"
+	health "google.golang.org/grpc/health/grpc_health_v1"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistrationChecks(t *testing.T) {
+	old := ServiceConf
+	defer func() { ServiceConf = old }()
+	ServiceConf = ServiceConfig{
+		ServiceName: "add",
+		ServicePort: "9785",
+		AdminPort:   "9786",
+		Checks: []CheckConfig{
+			{Type: CheckGRPC, GRPCService: "add.AddService"},
+			{Type: CheckHTTP},
+			{Type: CheckTTL, Name: "heartbeat"},
+		},
+	}
+	reg := NewRegisterContest().registration()
+	if reg.Check != nil || len(reg.Checks) != 3 {
+		t.Fatalf("checks= %+v, want 3", reg.Checks)
+	}
+	grpcCheck, httpCheck, ttlCheck := reg.Checks[0], reg.Checks[1], reg.Checks[2]
+	if !strings.HasSuffix(grpcCheck.GRPC, ":9785/add.AddService") || grpcCheck.Interval != "10s" {
+		t.Fatalf("grpc check= %+v", grpcCheck)
+	}
+	if !strings.HasSuffix(httpCheck.HTTP, ":9786/health") {
+		t.Fatalf("http check= %+v", httpCheck)
+	}
+	if ttlCheck.TTL != "30s" || ttlCheck.CheckID != "service:add:heartbeat" || ttlCheck.Interval != "" {
+		t.Fatalf("ttl check= %+v", ttlCheck)
+	}
+	ids := checkIDs(reg)
+	if ids[0] != "service:add:grpc" || ids[2] != "service:add:heartbeat" {
+		t.Fatalf("ids= %v", ids)
+	}
+
+	// 未配置时使用一个检查整个服务进程的gRPC检查
+	ServiceConf.Checks = nil
+	reg = NewRegisterContest().registration()
+	if len(reg.Checks) != 1 || !strings.HasSuffix(reg.Checks[0].GRPC, ":9785") {
+		t.Fatalf("default checks= %+v", reg.Checks)
+	}
+}
+
+func TestValidateChecks(t *testing.T) {
+	err := validateChecks([]CheckConfig{{Type: CheckHTTP}, {Type: "udp"}, {Type: CheckTTL}, {Type: CheckTTL}, {Type: CheckTTL, Name: "short", TTL: 2}}, "")
+	errs, ok := err.(ConfigErrors)
+	if !ok || len(errs) != 4 {
+		t.Fatalf("err= %v, want 4 errors", err)
+	}
+	if err := validateChecks([]CheckConfig{{Type: CheckHTTP}, {Type: CheckTTL}, {Type: CheckTTL, Name: "other"}}, "9786"); err != nil {
+		t.Fatalf("err= %v", err)
+	}
+}
+
+type fakeTTLAgent struct {
+	last string
+}
+
+func (a *fakeTTLAgent) PassTTL(checkID, note string) error {
+	a.last = "pass"
+	return nil
+}
+
+func (a *fakeTTLAgent) FailTTL(checkID, note string) error {
+	a.last = "fail"
+	return nil
+}
+
+func TestHealthState(t *testing.T) {
+	old := GHealthServer
+	defer func() { GHealthServer = old }()
+	GHealthServer = NewHealthServer()
+
+	agent := &fakeTTLAgent{}
+	reportTTL(agent, "service:add:ttl")
+	w := httptest.NewRecorder()
+	healthHandler(w, httptest.NewRequest("GET", "/health", nil))
+	if agent.last != "pass" || w.Code != 200 {
+		t.Fatalf("ttl= %v, http= %v, want pass/200 while serving", agent.last, w.Code)
+	}
+	resp, err := GHealthServer.Check(context.Background(), &health.HealthCheckRequest{})
+	if err != nil || resp.Status != health.HealthCheckResponse_SERVING {
+		t.Fatalf("resp= %v, err= %v", resp, err)
+	}
+
+	GHealthServer.SetServingStatus("", health.HealthCheckResponse_NOT_SERVING)
+	reportTTL(agent, "service:add:ttl")
+	w = httptest.NewRecorder()
+	healthHandler(w, httptest.NewRequest("GET", "/health", nil))
+	if agent.last != "fail" || w.Code != 503 {
+		t.Fatalf("ttl= %v, http= %v, want fail/503 while not serving", agent.last, w.Code)
+	}
+	if _, err := GHealthServer.Check(context.Background(), &health.HealthCheckRequest{Service: "unknown.Service"}); err == nil {
+		t.Fatalf("unknown service should return error")
+	}
+}
+
+type chanTTLAgent chan string
+
+func (a chanTTLAgent) PassTTL(checkID, note string) error {
+	a <- checkID
+	return nil
+}
+
+func (a chanTTLAgent) FailTTL(checkID, note string) error {
+	a <- checkID
+	return nil
+}
+
+// 服务停止后不再上报
+func TestHeartbeatStops(t *testing.T) {
+	agent := make(chanTTLAgent)
+	done, finished := make(chan struct{}), make(chan struct{})
+	go func() {
+		heartbeat(agent, "service:add:ttl", time.Millisecond, done)
+		close(finished)
+	}()
+	<-agent
+	close(done)
+	for {
+		select {
+		case <-agent:
+		case <-finished:
+			return
+		case <-time.After(time.Second):
+			t.Fatalf("heartbeat should stop after done closed")
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 携带请求优先级的metadata key，取值为PriorityHigh/PriorityNormal/PriorityLow
+const PriorityMetadataKey = "pika-priority"
+
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// 各优先级可使用的并发上限比例，高负载时低优先级请求先被丢弃
+var priorityQuota = map[string]float64{
+	PriorityHigh:   1.0,
+	PriorityNormal: 0.9,
+	PriorityLow:    0.7,
+}
+
+// ConcurrencyConfig 自适应并发限制配置，对应service_info.yml中的Concurrency段
+type ConcurrencyConfig struct {
+	Enabled      bool          `yaml:"Enabled"`
+	InitialLimit int           `yaml:"InitialLimit"` // 初始并发上限
+	MinLimit     int           `yaml:"MinLimit"`
+	MaxLimit     int           `yaml:"MaxLimit"`
+	Tolerance    float64       `yaml:"Tolerance"` // 平均延迟不超过 最小延迟*Tolerance 时视为未拥塞
+	Smoothing    float64       `yaml:"Smoothing"` // 每个窗口上限调整的平滑系数(0,1]
+	Window       time.Duration `yaml:"Window"`    // 采样窗口
+}
+
+func (c ConcurrencyConfig) withDefaults() ConcurrencyConfig {
+	if c.InitialLimit <= 0 {
+		c.InitialLimit = 20
+	}
+	if c.MinLimit <= 0 {
+		c.MinLimit = 5
+	}
+	if c.MaxLimit <= 0 {
+		c.MaxLimit = 1000
+	}
+	if c.Tolerance < 1 {
+		c.Tolerance = 1.5
+	}
+	if c.Smoothing <= 0 || c.Smoothing > 1 {
+		c.Smoothing = 0.2
+	}
+	if c.Window <= 0 {
+		c.Window = time.Second
+	}
+	return c
+}
+
+// 最小延迟每隔minRTTWindows个窗口重新探测一次，以适应基线延迟的变化
+const minRTTWindows = 30
+
+// ConcurrencyLimiter 基于延迟梯度的自适应并发限制
+// 每个窗口根据 最小延迟/平均延迟 的比值调整并发上限，超过上限的请求直接以Unavailable拒绝
+type ConcurrencyLimiter struct {
+	sync.Mutex
+	conf        ConcurrencyConfig
+	limit       float64
+	inflight    int
+	peak        int // 窗口内的最大并发
+	minRTT      time.Duration
+	rttSum      time.Duration
+	samples     int
+	windows     int
+	windowStart time.Time
+}
+
+func NewConcurrencyLimiter(conf ConcurrencyConfig) *ConcurrencyLimiter {
+	conf = conf.withDefaults()
+	return &ConcurrencyLimiter{
+		conf:        conf,
+		limit:       float64(conf.InitialLimit),
+		windowStart: time.Now(),
+	}
+}
+
+// Limit 当前的并发上限
+func (l *ConcurrencyLimiter) Limit() int {
+	l.Lock()
+	defer l.Unlock()
+	return int(l.limit)
+}
+
+// Update 运行时更新并发限制配置，当前上限会被限制在新的[MinLimit, MaxLimit]内
+func (l *ConcurrencyLimiter) Update(conf ConcurrencyConfig) {
+	conf = conf.withDefaults()
+	l.Lock()
+	defer l.Unlock()
+	if conf.Enabled && !l.conf.Enabled {
+		l.limit = float64(conf.InitialLimit)
+	}
+	l.conf = conf
+	l.limit = math.Max(float64(conf.MinLimit), math.Min(float64(conf.MaxLimit), l.limit))
+}
+
+func (l *ConcurrencyLimiter) enabled() bool {
+	l.Lock()
+	defer l.Unlock()
+	return l.conf.Enabled
+}
+
+func (l *ConcurrencyLimiter) acquire(priority string) bool {
+	quota, ok := priorityQuota[priority]
+	if !ok {
+		quota = priorityQuota[PriorityNormal]
+	}
+	l.Lock()
+	defer l.Unlock()
+	if float64(l.inflight) >= math.Max(1, l.limit*quota) {
+		return false
+	}
+	l.inflight++
+	if l.inflight > l.peak {
+		l.peak = l.inflight
+	}
+	return true
+}
+
+func (l *ConcurrencyLimiter) release(rtt time.Duration, now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+	l.inflight--
+	l.rttSum += rtt
+	l.samples++
+	if l.minRTT == 0 || rtt < l.minRTT {
+		l.minRTT = rtt
+	}
+	if now.Sub(l.windowStart) >= l.conf.Window {
+		l.adjust()
+		l.windowStart = now
+	}
+}
+
+// 按延迟梯度调整并发上限，调用方需持有锁
+func (l *ConcurrencyLimiter) adjust() {
+	if l.samples == 0 {
+		return
+	}
+	avgRTT := l.rttSum / time.Duration(l.samples)
+	gradient := 1.0
+	if avgRTT > 0 {
+		gradient = math.Max(0.5, math.Min(1, l.conf.Tolerance*float64(l.minRTT)/float64(avgRTT)))
+	}
+	newLimit := l.limit * gradient
+	// 只有并发确实接近上限时才放大，避免空闲时上限无限增长
+	if float64(l.peak)*2 >= l.limit {
+		newLimit += math.Sqrt(l.limit)
+	}
+	l.limit = l.limit*(1-l.conf.Smoothing) + newLimit*l.conf.Smoothing
+	l.limit = math.Max(float64(l.conf.MinLimit), math.Min(float64(l.conf.MaxLimit), l.limit))
+
+	l.windows++
+	if l.windows%minRTTWindows == 0 {
+		l.minRTT = avgRTT
+	}
+	l.rttSum, l.samples, l.peak = 0, 0, l.inflight
+}
+
+func priority(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(PriorityMetadataKey); len(values) > 0 {
+			return values[0]
+		}
+	}
+	return PriorityNormal
+}
+
+// UnaryInterceptor 丢弃已超时的请求，开启并发限制时对超过上限的请求返回Unavailable
+// gRPC健康检查不受并发限制，避免过载时consul将实例判为不健康
+func (l *ConcurrencyLimiter) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return nil, status.Errorf(codes.DeadlineExceeded, "deadline exceeded before handling %v", info.FullMethod)
+	}
+	if !l.enabled() || strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+		return handler(ctx, req)
+	}
+	if !l.acquire(priority(ctx)) {
+		return nil, status.Errorf(codes.Unavailable, "server overloaded, concurrency limit= %v", l.Limit())
+	}
+	start := time.Now()
+	defer func() {
+		now := time.Now()
+		l.release(now.Sub(start), now)
+	}()
+	return handler(ctx, req)
+}
+
+var GConcurrencyLimiter *ConcurrencyLimiter // 全局并发限制器
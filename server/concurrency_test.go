@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiterPriority(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyConfig{Enabled: true, InitialLimit: 10})
+	for i := 0; i < 7; i++ {
+		if !l.acquire(PriorityLow) {
+			t.Fatalf("low priority request %v should be admitted", i)
+		}
+	}
+	if l.acquire(PriorityLow) {
+		t.Fatalf("low priority request should be shed above 70%% of limit")
+	}
+	if !l.acquire(PriorityNormal) || !l.acquire(PriorityNormal) {
+		t.Fatalf("normal priority requests should be admitted up to 90%% of limit")
+	}
+	if l.acquire(PriorityNormal) {
+		t.Fatalf("normal priority request should be shed above 90%% of limit")
+	}
+	if !l.acquire(PriorityHigh) {
+		t.Fatalf("high priority request should be admitted up to limit")
+	}
+	if l.acquire(PriorityHigh) {
+		t.Fatalf("high priority request should be shed above limit")
+	}
+}
+
+func TestConcurrencyLimiterAdjust(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyConfig{Enabled: true, InitialLimit: 100, Smoothing: 1})
+	now := time.Now()
+
+	// 延迟稳定时，并发接近上限则上限增长
+	for i := 0; i < 61; i++ {
+		l.acquire(PriorityHigh)
+	}
+	for i := 0; i < 60; i++ {
+		l.release(10*time.Millisecond, now)
+	}
+	l.release(10*time.Millisecond, now.Add(time.Second))
+	if limit := l.Limit(); limit <= 100 {
+		t.Fatalf("limit= %v, want > 100", limit)
+	}
+
+	// 延迟明显升高时上限下降
+	before := l.Limit()
+	l.acquire(PriorityHigh)
+	l.release(100*time.Millisecond, now.Add(2*time.Second))
+	if limit := l.Limit(); limit >= before {
+		t.Fatalf("limit= %v, want < %v", limit, before)
+	}
+}
+
+func TestConcurrencyLimiterSkipHealth(t *testing.T) {
+	l := NewConcurrencyLimiter(ConcurrencyConfig{Enabled: true, InitialLimit: 1})
+	if !l.acquire(PriorityHigh) {
+		t.Fatalf("first request should be admitted")
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	info := &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+	if _, err := l.UnaryInterceptor(context.Background(), nil, info, handler); err != nil {
+		t.Fatalf("health check should not be shed, err= %v", err)
+	}
+	info = &grpc.UnaryServerInfo{FullMethod: "/add.AddService/Add"}
+	if _, err := l.UnaryInterceptor(context.Background(), nil, info, handler); err == nil {
+		t.Fatalf("request above limit should be shed")
+	}
+}
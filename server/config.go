@@ -1,35 +1,102 @@
 package server
 
 import (
-	"gopkg.in/yaml.v2"
-	"io/ioutil"
+	"github.com/Carey6918/PikaRPC/helper"
 	"log"
 	"os"
-	"path/filepath"
+	"sync"
 )
 
 var ConfigDir string
 
 const ServiceConfigFile = "service_info.yml"
 
+// ServiceConf 当前生效的服务配置，SIGHUP时会被替换，启动后在其他goroutine中读取请使用GetServiceConf
 var ServiceConf ServiceConfig
 
+var serviceConfMu sync.RWMutex
+
+// GetServiceConf 返回当前生效的服务配置
+func GetServiceConf() ServiceConfig {
+	serviceConfMu.RLock()
+	defer serviceConfMu.RUnlock()
+	return ServiceConf
+}
+
+// 发布新的服务配置，helper中的默认consul、IP配置随之更新
+func setServiceConf(conf ServiceConfig) {
+	serviceConfMu.Lock()
+	ServiceConf = conf
+	helper.SetDefaultConsulConfig(conf.Consul)
+	helper.SetDefaultIPConfig(conf.IP)
+	serviceConfMu.Unlock()
+}
+
 type ServiceConfig struct {
-	ServiceName string `yaml:"ServiceName"`
-	ServicePort string `yaml:"ServicePort"`
+	ServiceName  string              `yaml:"ServiceName" validate:"required"`
+	ServicePort  string              `yaml:"ServicePort" validate:"required"`
+	Zone         string              `yaml:"Zone"`         // 所在可用区，为空时取环境变量PIKA_ZONE
+	Tags         []string            `yaml:"Tags"`         // 注册到consul的tags，如canary
+	Meta         map[string]string   `yaml:"Meta"`         // 注册到consul的服务Meta
+	Shards       []int               `yaml:"Shards"`       // 本实例拥有的分片ID
+	ConfigPrefix string              `yaml:"ConfigPrefix"` // 动态配置在consul KV中的前缀，默认为pika/config/<ServiceName>/
+	RateLimit    RateLimitConfig     `yaml:"RateLimit"`
+	Concurrency  ConcurrencyConfig   `yaml:"Concurrency"`
+	Log          LogConfig           `yaml:"Log"`
+	Features     FeatureConfig       `yaml:"Features"`
+	AdminPort    string              `yaml:"AdminPort"`  // 管理接口的HTTP端口，为空时不开启
+	AdminToken   string              `yaml:"AdminToken"` // 访问管理接口需携带的token，为空时只允许本机访问(会额外监听回环地址)，/health除外
+	Consul       helper.ConsulConfig `yaml:"Consul"`
+	Register     RegisterConfig      `yaml:"Register"`
+	Checks       []CheckConfig       `yaml:"Checks"` // 健康检查，为空时使用一个gRPC检查
+	GRPCServices GRPCServicesConfig  `yaml:"GRPCServices"`
+
+	// 监听地址，如0.0.0.0，默认为本机IP；ServicePort为0时由系统分配端口，注册实际监听的端口
+	BindAddress string `yaml:"BindAddress"`
+	// 注册到consul的地址，未设置时依次取环境变量AdvertiseAddressEnv、网卡AdvertiseInterface上的地址，默认同监听地址
+	AdvertiseAddress    string `yaml:"AdvertiseAddress"`
+	AdvertiseAddressEnv string `yaml:"AdvertiseAddressEnv"` // 如HOST_IP
+	AdvertiseInterface  string `yaml:"AdvertiseInterface"`  // 如eth0
+	// 本机IP的选择规则，监听、注册和consul的默认地址都按此选择
+	IP helper.IPConfig `yaml:"IP"`
+}
+
+func (c *ServiceConfig) Validate() error {
+	var errs ConfigErrors
+	if err := validateChecks(c.Checks, c.AdminPort); err != nil {
+		errs = append(errs, err.(ConfigErrors)...)
+	}
+	if err := c.GRPCServices.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
 }
 
 func InitConfig() {
 	ConfigDir = os.Getenv("CONF_DIR")
-	LoadServiceConfig()
+	if err := LoadServiceConfig(); err != nil {
+		log.Fatalf("load service config failed, err= %v", err)
+	}
 }
 
-func LoadServiceConfig() {
-	configFile, err := ioutil.ReadFile(filepath.Join(ConfigDir, ServiceConfigFile))
+// LoadServiceConfig 重新加载ServiceConf，加载或校验失败时保留原配置
+func LoadServiceConfig() error {
+	conf, err := loadServiceConfig()
 	if err != nil {
-		log.Fatalf("ReadFile, err= %v", err)
-		return
+		return err
+	}
+	setServiceConf(conf)
+	return nil
+}
+
+func loadServiceConfig() (ServiceConfig, error) {
+	var conf ServiceConfig
+	if err := LoadConfig(&conf); err != nil {
+		return conf, err
 	}
-	yaml.Unmarshal(configFile, &ServiceConf)
-	log.Printf("Conf=%v", ServiceConf)
+	log.Printf("Conf=%v", conf)
+	return conf, nil
 }
@@ -0,0 +1,346 @@
+package server
+
+import (
+	"code.byted.org/gopkg/pkg/log"
+	"fmt"
+	"github.com/Carey6918/PikaRPC/helper"
+	"github.com/hashicorp/consul/api"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// consul不可用时使用的本地快照文件，位于ConfigDir下
+const ConfigSnapshotFile = "service_info.snapshot.yml"
+
+// DynamicConfig 动态配置，consul KV中的值覆盖service_info.yml中的同名配置
+// key为以点分隔的路径，如"RateLimit.DefaultCaller.QPS"，对应KV中的"<prefix>RateLimit.DefaultCaller.QPS"或"<prefix>RateLimit/DefaultCaller/QPS"
+type DynamicConfig struct {
+	sync.RWMutex
+	prefix   string
+	local    map[interface{}]interface{} // service_info.yml中的默认值
+	remote   map[string]string           // consul KV中的值
+	watchers map[string][]func(old, new string)
+	done     chan struct{}
+}
+
+var DynConf *DynamicConfig // 全局动态配置
+
+// 动态配置的KV前缀，会加上consul配置的Prefix
+func configPrefix() string {
+	prefix := ServiceConf.ConfigPrefix
+	if prefix == "" {
+		prefix = fmt.Sprintf("pika/config/%v/", ServiceConf.ServiceName)
+	}
+	return ServiceConf.Consul.Key(prefix)
+}
+
+// InitDynamicConfig 加载本地默认值和consul KV中的配置，并在后台持续监听KV变化
+func InitDynamicConfig() {
+	DynConf = NewDynamicConfig(configPrefix())
+	if err := DynConf.loadLocal(configFiles()...); err != nil {
+		log.Errorf("load local config defaults failed, err= %v", err)
+	}
+	client, err := newConsulClient()
+	if err != nil {
+		log.Errorf("consul new client failed, dynamic config disabled, err= %v", err)
+		return
+	}
+	DynConf.Start(client)
+}
+
+func NewDynamicConfig(prefix string) *DynamicConfig {
+	return &DynamicConfig{
+		prefix:   prefix,
+		local:    make(map[interface{}]interface{}),
+		remote:   make(map[string]string),
+		watchers: make(map[string][]func(old, new string)),
+		done:     make(chan struct{}),
+	}
+}
+
+// 依次加载配置文件，后面的文件覆盖前面的同名配置，环境配置文件不存在时忽略
+func (c *DynamicConfig) loadLocal(files ...string) error {
+	local := make(map[interface{}]interface{})
+	for i, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			if i > 0 && os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		overlay := make(map[interface{}]interface{})
+		if err := yaml.Unmarshal(data, &overlay); err != nil {
+			return err
+		}
+		mergeYAML(local, overlay)
+	}
+	c.Lock()
+	changes := c.replace(local, c.remote)
+	c.Unlock()
+	notify(changes)
+	return nil
+}
+
+func mergeYAML(dst, src map[interface{}]interface{}) {
+	for k, v := range src {
+		srcMap, ok1 := v.(map[interface{}]interface{})
+		dstMap, ok2 := dst[k].(map[interface{}]interface{})
+		if ok1 && ok2 {
+			mergeYAML(dstMap, srcMap)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// Start 同步读取一次consul KV，失败时使用本地快照，随后在后台监听KV变化
+func (c *DynamicConfig) Start(client *api.Client) {
+	var index uint64
+	pairs, meta, err := client.KV().List(c.prefix, nil)
+	if err != nil {
+		log.Warnf("load dynamic config from consul failed, use snapshot, err= %v", err)
+		c.loadSnapshot()
+	} else {
+		c.update(pairs)
+		index = meta.LastIndex
+	}
+	// 从已读取的index之后开始监听，避免同一份配置再应用一次
+	go helper.WatchKVPrefix(client, c.prefix, index, c.done, c.update)
+}
+
+// Stop 停止监听KV变化
+func (c *DynamicConfig) Stop() {
+	close(c.done)
+}
+
+func (c *DynamicConfig) snapshotFile() string {
+	return filepath.Join(ConfigDir, ConfigSnapshotFile)
+}
+
+func (c *DynamicConfig) loadSnapshot() {
+	data, err := ioutil.ReadFile(c.snapshotFile())
+	if err != nil {
+		log.Warnf("read config snapshot failed, err= %v", err)
+		return
+	}
+	remote := make(map[string]string)
+	if err := yaml.Unmarshal(data, &remote); err != nil {
+		log.Errorf("parse config snapshot failed, err= %v", err)
+		return
+	}
+	c.apply(remote)
+}
+
+func (c *DynamicConfig) saveSnapshot(remote map[string]string) {
+	data, err := yaml.Marshal(remote)
+	if err == nil {
+		err = ioutil.WriteFile(c.snapshotFile(), data, 0644)
+	}
+	if err != nil {
+		log.Warnf("save config snapshot failed, err= %v", err)
+	}
+}
+
+func (c *DynamicConfig) update(pairs api.KVPairs) {
+	remote := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key := strings.TrimPrefix(pair.Key, c.prefix)
+		if key == "" || strings.HasSuffix(key, "/") {
+			continue
+		}
+		remote[strings.Replace(key, "/", ".", -1)] = string(pair.Value)
+	}
+	c.apply(remote)
+	c.saveSnapshot(remote)
+}
+
+// 替换KV中的配置，记录变化并通知监听者
+func (c *DynamicConfig) apply(remote map[string]string) {
+	c.Lock()
+	old := c.remote
+	changes := c.replace(c.local, remote)
+	c.Unlock()
+
+	for key, value := range remote {
+		if oldValue, ok := old[key]; !ok || oldValue != value {
+			log.Infof("dynamic config %v changed, old= %q, new= %q", key, oldValue, value)
+		}
+	}
+	for key, oldValue := range old {
+		if _, ok := remote[key]; !ok {
+			log.Infof("dynamic config %v removed, old= %q", key, oldValue)
+		}
+	}
+	notify(changes)
+}
+
+type change struct {
+	old, new string
+	fns      []func(old, new string)
+}
+
+// 替换本地默认值和KV中的配置，返回值发生变化的监听项，调用方需持有锁
+// 持有锁时复制回调，Watch可能同时修改watchers
+func (c *DynamicConfig) replace(local map[interface{}]interface{}, remote map[string]string) []change {
+	var changes []change
+	for key, fns := range c.watchers {
+		oldValue, newValue := valueOf(c.local, c.remote, key), valueOf(local, remote, key)
+		if oldValue != newValue {
+			changes = append(changes, change{oldValue, newValue, append([]func(old, new string){}, fns...)})
+		}
+	}
+	c.local, c.remote = local, remote
+	return changes
+}
+
+// 在锁外执行回调
+func notify(changes []change) {
+	for _, ch := range changes {
+		for _, fn := range ch.fns {
+			fn(ch.old, ch.new)
+		}
+	}
+}
+
+// 取key对应的原始YAML文本，key为父节点时KV中子节点的值覆盖本地的同名配置
+func valueOf(local map[interface{}]interface{}, remote map[string]string, key string) string {
+	if value, ok := remote[key]; ok {
+		return value
+	}
+	node := lookupYAML(local, key)
+	var merged map[interface{}]interface{}
+	for k, value := range remote {
+		if !strings.HasPrefix(k, key+".") {
+			continue
+		}
+		if merged == nil {
+			// 复制本地的子树后再覆盖，不修改local
+			if merged, _ = copyYAML(node).(map[interface{}]interface{}); merged == nil {
+				merged = make(map[interface{}]interface{})
+			}
+			node = merged
+		}
+		setYAML(merged, strings.Split(k[len(key)+1:], "."), value)
+	}
+	if node == nil {
+		return ""
+	}
+	if s, ok := node.(string); ok {
+		return s
+	}
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(string(data), "\n")
+}
+
+func lookupYAML(node interface{}, key string) interface{} {
+	for _, part := range strings.Split(key, ".") {
+		m, ok := node.(map[interface{}]interface{})
+		if !ok {
+			return nil
+		}
+		if node, ok = m[part]; !ok {
+			return nil
+		}
+	}
+	return node
+}
+
+func copyYAML(node interface{}) interface{} {
+	m, ok := node.(map[interface{}]interface{})
+	if !ok {
+		return node
+	}
+	copied := make(map[interface{}]interface{}, len(m))
+	for k, v := range m {
+		copied[k] = copyYAML(v)
+	}
+	return copied
+}
+
+// 按路径设置值，value按YAML解析，如"200"解析为整数
+func setYAML(m map[interface{}]interface{}, path []string, value string) {
+	for _, part := range path[:len(path)-1] {
+		child, ok := m[part].(map[interface{}]interface{})
+		if !ok {
+			child = make(map[interface{}]interface{})
+			m[part] = child
+		}
+		m = child
+	}
+	var parsed interface{}
+	if err := yaml.Unmarshal([]byte(value), &parsed); err != nil || parsed == nil {
+		parsed = value
+	}
+	m[path[len(path)-1]] = parsed
+}
+
+// Raw 返回key对应的原始文本，不存在时返回空字符串
+func (c *DynamicConfig) Raw(key string) string {
+	c.RLock()
+	defer c.RUnlock()
+	return valueOf(c.local, c.remote, key)
+}
+
+// Bind 将key对应的配置解析到out，out可以是结构体、map、slice等任意YAML可解析的类型
+func (c *DynamicConfig) Bind(key string, out interface{}) error {
+	raw := c.Raw(key)
+	if raw == "" {
+		return fmt.Errorf("config %v not found", key)
+	}
+	return yaml.Unmarshal([]byte(raw), out)
+}
+
+func (c *DynamicConfig) GetString(key, def string) string {
+	if v := c.Raw(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func (c *DynamicConfig) GetInt(key string, def int) int {
+	var v int
+	if err := c.Bind(key, &v); err != nil {
+		return def
+	}
+	return v
+}
+
+func (c *DynamicConfig) GetFloat(key string, def float64) float64 {
+	var v float64
+	if err := c.Bind(key, &v); err != nil {
+		return def
+	}
+	return v
+}
+
+func (c *DynamicConfig) GetBool(key string, def bool) bool {
+	var v bool
+	if err := c.Bind(key, &v); err != nil {
+		return def
+	}
+	return v
+}
+
+func (c *DynamicConfig) GetDuration(key string, def time.Duration) time.Duration {
+	v, err := time.ParseDuration(c.GetString(key, ""))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// Watch 监听key的变化，回调参数为变化前后的原始文本
+func (c *DynamicConfig) Watch(key string, fn func(old, new string)) {
+	c.Lock()
+	defer c.Unlock()
+	c.watchers[key] = append(c.watchers[key], fn)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"github.com/hashicorp/consul/api"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDynamicConfigLayering(t *testing.T) {
+	c := NewDynamicConfig("pika/config/add/")
+	c.local = map[interface{}]interface{}{
+		"ServiceName": "add",
+		"RateLimit": map[interface{}]interface{}{
+			"DefaultCaller": map[interface{}]interface{}{"QPS": 100, "Burst": 10},
+		},
+		"Timeout": "1s",
+	}
+
+	if got := c.GetString("ServiceName", ""); got != "add" {
+		t.Fatalf("ServiceName= %q, want add", got)
+	}
+	if got := c.GetInt("RateLimit.DefaultCaller.QPS", 0); got != 100 {
+		t.Fatalf("QPS= %v, want local default 100", got)
+	}
+	if got := c.GetDuration("Timeout", 0); got != time.Second {
+		t.Fatalf("Timeout= %v, want 1s", got)
+	}
+	if got := c.GetBool("Missing", true); !got {
+		t.Fatalf("missing key should return default")
+	}
+
+	c.apply(map[string]string{"RateLimit.DefaultCaller.QPS": "200"})
+	if got := c.GetInt("RateLimit.DefaultCaller.QPS", 0); got != 200 {
+		t.Fatalf("QPS= %v, want remote value 200", got)
+	}
+	var limit Limit
+	if err := c.Bind("RateLimit.DefaultCaller", &limit); err != nil {
+		t.Fatalf("bind failed, err= %v", err)
+	}
+	if limit.QPS != 200 || limit.Burst != 10 {
+		t.Fatalf("limit= %+v, want remote QPS merged into local subtree", limit)
+	}
+	if limit := c.Raw("RateLimit"); !strings.Contains(limit, "QPS: 200") {
+		t.Fatalf("RateLimit= %q, want remote QPS merged", limit)
+	}
+	if c.local["RateLimit"].(map[interface{}]interface{})["DefaultCaller"].(map[interface{}]interface{})["QPS"] != 100 {
+		t.Fatalf("local defaults should not be modified")
+	}
+}
+
+func TestDynamicConfigWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dynconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ConfigDir = dir
+	defer func() { ConfigDir = "" }()
+
+	c := NewDynamicConfig("pika/config/add/")
+	c.local = map[interface{}]interface{}{"Level": "info"}
+
+	var changes [][2]string
+	c.Watch("Level", func(old, new string) {
+		changes = append(changes, [2]string{old, new})
+	})
+
+	c.update(api.KVPairs{
+		{Key: "pika/config/add/"},
+		{Key: "pika/config/add/Level", Value: []byte("debug")},
+	})
+	c.update(api.KVPairs{{Key: "pika/config/add/Other", Value: []byte("x")}})
+
+	if len(changes) != 2 {
+		t.Fatalf("changes= %v, want 2", changes)
+	}
+	if changes[0] != [2]string{"info", "debug"} || changes[1] != [2]string{"debug", "info"} {
+		t.Fatalf("changes= %v", changes)
+	}
+
+	// consul不可用时从快照恢复
+	restored := NewDynamicConfig("pika/config/add/")
+	restored.loadSnapshot()
+	if got := restored.GetString("Other", ""); got != "x" {
+		t.Fatalf("Other= %q, want x from snapshot", got)
+	}
+
+	// 子节点变化时父节点的监听者也会收到通知
+	parent := 0
+	c.Watch("RateLimit", func(old, new string) { parent++ })
+	c.update(api.KVPairs{{Key: "pika/config/add/RateLimit/DefaultCaller/QPS", Value: []byte("200")}})
+	if parent != 1 {
+		t.Fatalf("parent watcher called %v times, want 1", parent)
+	}
+}
+
+// 重新加载本地配置时通知监听者
+func TestDynamicConfigReloadLocal(t *testing.T) {
+	defer writeConfigFiles(t, map[string]string{"service_info.yml": "Level: info\n"})()
+	c := NewDynamicConfig("pika/config/add/")
+	if err := c.loadLocal(configFiles()...); err != nil {
+		t.Fatal(err)
+	}
+	var changes [][2]string
+	c.Watch("Level", func(old, new string) {
+		changes = append(changes, [2]string{old, new})
+	})
+	ioutil.WriteFile(filepath.Join(ConfigDir, ServiceConfigFile), []byte("Level: debug\n"), 0644)
+	if err := c.loadLocal(configFiles()...); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0] != [2]string{"info", "debug"} {
+		t.Fatalf("changes= %v, want local change notified", changes)
+	}
+}
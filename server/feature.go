@@ -0,0 +1,230 @@
+package server
+
+import (
+	"code.byted.org/gopkg/pkg/log"
+	"context"
+	"github.com/Carey6918/PikaRPC/client"
+	"github.com/Carey6918/PikaRPC/helper"
+	"github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/metadata"
+	"gopkg.in/yaml.v2"
+	"hash/fnv"
+	"io/ioutil"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FeatureConfig 功能开关的来源，对应service_info.yml中的Features段，同时设置时以consul KV为准
+type FeatureConfig struct {
+	File     string        `yaml:"File"`                   // 本地开关文件
+	KVKey    string        `yaml:"KVKey"`                  // consul KV中开关文档的key，会加上consul配置的Prefix
+	Interval time.Duration `yaml:"Interval" default:"10s"` // 检查本地文件的间隔
+}
+
+// FeatureDocument 开关文档，YAML格式
+type FeatureDocument struct {
+	Flags map[string]FeatureFlag `yaml:"Flags"`
+}
+
+// FeatureFlag 单个开关的规则
+// Enabled为false时对所有请求关闭；白名单和百分比都未设置时为布尔开关；
+// 否则命中调用方白名单、属性白名单或百分比放量中任意一个即开启
+type FeatureFlag struct {
+	Enabled    bool                `yaml:"Enabled"`
+	Callers    []string            `yaml:"Callers"`    // 调用方服务名白名单
+	Attributes map[string][]string `yaml:"Attributes"` // 请求属性白名单，属性名 -> 允许的取值
+	Percent    float64             `yaml:"Percent"`    // 放量比例[0,100]
+	Key        string              `yaml:"Key"`        // 百分比放量时作为hash依据的属性，默认为调用方服务名
+}
+
+// FeatureContext 开关求值的上下文
+type FeatureContext struct {
+	Caller     string            `json:"caller"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+// FeatureContextFrom 从请求的metadata中取调用方和请求属性，每个metadata key取第一个值作为属性
+// 调用方取自限流配置的CallerKey，与限流识别的调用方一致
+func FeatureContextFrom(ctx context.Context) FeatureContext {
+	fc := FeatureContext{Attributes: make(map[string]string)}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		if len(values) > 0 {
+			fc.Attributes[key] = values[0]
+		}
+	}
+	fc.Caller = fc.Attributes[callerKey()]
+	return fc
+}
+
+func callerKey() string {
+	if GRateLimiter != nil {
+		return GRateLimiter.CallerKey()
+	}
+	return client.CallerMetadataKey
+}
+
+// 开关求值的原因
+const (
+	ReasonNotFound           = "not_found"
+	ReasonDisabled           = "disabled"
+	ReasonDefault            = "default"
+	ReasonCallerAllowlist    = "caller_allowlist"
+	ReasonAttributeAllowlist = "attribute_allowlist"
+	ReasonPercentage         = "percentage"
+	ReasonNoMatch            = "no_match"
+)
+
+// Evaluation 开关的求值结果
+type Evaluation struct {
+	Flag    string `json:"flag"`
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason"`
+}
+
+func (f *FeatureFlag) evaluate(name string, fc FeatureContext) (bool, string) {
+	if !f.Enabled {
+		return false, ReasonDisabled
+	}
+	if len(f.Callers) == 0 && len(f.Attributes) == 0 && f.Percent <= 0 {
+		return true, ReasonDefault
+	}
+	for _, caller := range f.Callers {
+		if caller == fc.Caller {
+			return true, ReasonCallerAllowlist
+		}
+	}
+	for attr, allowed := range f.Attributes {
+		value, ok := fc.Attributes[attr]
+		if !ok {
+			continue
+		}
+		for _, v := range allowed {
+			if v == value {
+				return true, ReasonAttributeAllowlist
+			}
+		}
+	}
+	if f.Percent >= 100 {
+		return true, ReasonPercentage
+	}
+	key := fc.Caller
+	if f.Key != "" {
+		key = fc.Attributes[f.Key]
+	}
+	// 同一个key对同一个开关的结果稳定，放量比例增大时已开启的key保持开启
+	if f.Percent > 0 && key != "" && bucket(name, key) < f.Percent*100 {
+		return true, ReasonPercentage
+	}
+	return false, ReasonNoMatch
+}
+
+// 将key映射到[0,10000)
+func bucket(name, key string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(name + "|" + key))
+	return float64(h.Sum32() % 10000)
+}
+
+// FeatureFlags 持有当前生效的开关，在本地求值
+type FeatureFlags struct {
+	sync.RWMutex
+	flags map[string]FeatureFlag
+}
+
+var GFeatures = NewFeatureFlags() // 全局功能开关
+
+func NewFeatureFlags() *FeatureFlags {
+	return &FeatureFlags{flags: make(map[string]FeatureFlag)}
+}
+
+// Update 替换当前的开关
+func (f *FeatureFlags) Update(doc FeatureDocument) {
+	f.Lock()
+	defer f.Unlock()
+	f.flags = doc.Flags
+}
+
+// Evaluate 对开关求值，开关不存在时为关闭
+func (f *FeatureFlags) Evaluate(name string, fc FeatureContext) Evaluation {
+	f.RLock()
+	flag, ok := f.flags[name]
+	f.RUnlock()
+	if !ok {
+		return Evaluation{Flag: name, Reason: ReasonNotFound}
+	}
+	enabled, reason := flag.evaluate(name, fc)
+	return Evaluation{Flag: name, Enabled: enabled, Reason: reason}
+}
+
+// EvaluateAll 对所有开关求值，按名称排序
+func (f *FeatureFlags) EvaluateAll(fc FeatureContext) []Evaluation {
+	f.RLock()
+	names := make([]string, 0, len(f.flags))
+	for name := range f.flags {
+		names = append(names, name)
+	}
+	f.RUnlock()
+	sort.Strings(names)
+	evals := make([]Evaluation, 0, len(names))
+	for _, name := range names {
+		evals = append(evals, f.Evaluate(name, fc))
+	}
+	return evals
+}
+
+// Enabled 以请求的调用方和metadata对开关求值
+func (f *FeatureFlags) Enabled(ctx context.Context, name string) bool {
+	return f.Evaluate(name, FeatureContextFrom(ctx)).Enabled
+}
+
+// FeatureEnabled 使用全局功能开关求值
+func FeatureEnabled(ctx context.Context, name string) bool {
+	return GFeatures.Enabled(ctx, name)
+}
+
+func (f *FeatureFlags) load(source string, data []byte) {
+	var doc FeatureDocument
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		log.Errorf("parse feature flags from %v failed, keep current flags, err= %v", source, err)
+		return
+	}
+	f.Update(doc)
+	log.Infof("feature flags updated from %v, flags= %v", source, len(doc.Flags))
+}
+
+func (f *FeatureFlags) watchKV(key string, done <-chan struct{}) {
+	client, err := newConsulClient()
+	if err != nil {
+		log.Errorf("consul new client failed, feature flags from kv %v disabled, err= %v", key, err)
+		return
+	}
+	helper.WatchKV(client, key, done, func(pair *api.KVPair) {
+		if pair == nil {
+			log.Warnf("feature flags kv %v not found, keep current flags", key)
+			return
+		}
+		f.load("kv "+key, pair.Value)
+	})
+}
+
+// Start 开始加载开关，本地文件同步加载一次
+func (f *FeatureFlags) Start(conf FeatureConfig, done <-chan struct{}) {
+	if conf.Interval <= 0 {
+		conf.Interval = 10 * time.Second
+	}
+	if conf.File != "" {
+		if data, err := ioutil.ReadFile(conf.File); err == nil {
+			f.load(conf.File, data)
+		}
+		if conf.KVKey == "" {
+			go helper.WatchFile(conf.File, conf.Interval, done, func(data []byte) {
+				f.load(conf.File, data)
+			})
+		}
+	}
+	if conf.KVKey != "" {
+		go f.watchKV(ServiceConf.Consul.Key(conf.KVKey), done)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Carey6918/PikaRPC/client"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testFeatureDoc = `
+Flags:
+  on: {Enabled: true}
+  off: {Enabled: false, Callers: [order]}
+  beta:
+    Enabled: true
+    Callers: [order]
+    Attributes: {uid: ["42"]}
+  rollout: {Enabled: true, Percent: 30, Key: uid}
+`
+
+func TestFeatureFlagsEvaluate(t *testing.T) {
+	f := NewFeatureFlags()
+	f.load("test", []byte(testFeatureDoc))
+
+	cases := []struct {
+		flag   string
+		fc     FeatureContext
+		want   bool
+		reason string
+	}{
+		{"missing", FeatureContext{}, false, ReasonNotFound},
+		{"on", FeatureContext{}, true, ReasonDefault},
+		{"off", FeatureContext{Caller: "order"}, false, ReasonDisabled},
+		{"beta", FeatureContext{Caller: "order"}, true, ReasonCallerAllowlist},
+		{"beta", FeatureContext{Caller: "user", Attributes: map[string]string{"uid": "42"}}, true, ReasonAttributeAllowlist},
+		{"beta", FeatureContext{Caller: "user", Attributes: map[string]string{"uid": "7"}}, false, ReasonNoMatch},
+		{"rollout", FeatureContext{Caller: "order"}, false, ReasonNoMatch},
+	}
+	for _, c := range cases {
+		eval := f.Evaluate(c.flag, c.fc)
+		if eval.Enabled != c.want || eval.Reason != c.reason {
+			t.Fatalf("%v with %+v = %+v, want %v/%v", c.flag, c.fc, eval, c.want, c.reason)
+		}
+	}
+}
+
+func TestFeatureFlagsPercentage(t *testing.T) {
+	f := NewFeatureFlags()
+	f.load("test", []byte(testFeatureDoc))
+
+	enabled := 0
+	for i := 0; i < 10000; i++ {
+		fc := FeatureContext{Attributes: map[string]string{"uid": fmt.Sprint(i)}}
+		first := f.Evaluate("rollout", fc).Enabled
+		if first != f.Evaluate("rollout", fc).Enabled {
+			t.Fatalf("evaluation of uid %v is not stable", i)
+		}
+		if first {
+			enabled++
+		}
+	}
+	if enabled < 2700 || enabled > 3300 {
+		t.Fatalf("enabled= %v of 10000, want about 30%%", enabled)
+	}
+}
+
+func TestFeatureContextFrom(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(client.CallerMetadataKey, "order", "uid", "42", "x-app", "pay"))
+	fc := FeatureContextFrom(ctx)
+	if fc.Caller != "order" || fc.Attributes["uid"] != "42" {
+		t.Fatalf("context= %+v", fc)
+	}
+
+	// 调用方与限流使用相同的CallerKey
+	old := GRateLimiter
+	defer func() { GRateLimiter = old }()
+	GRateLimiter = NewRateLimiter(RateLimitConfig{CallerKey: "x-app"})
+	if fc := FeatureContextFrom(ctx); fc.Caller != "pay" {
+		t.Fatalf("caller= %v, want pay from CallerKey", fc.Caller)
+	}
+}
+
+func TestFeaturesHandler(t *testing.T) {
+	old := GFeatures
+	defer func() { GFeatures = old }()
+	GFeatures = NewFeatureFlags()
+	GFeatures.load("test", []byte(testFeatureDoc))
+
+	local := func(target string) *http.Request {
+		r := httptest.NewRequest("GET", target, nil)
+		r.RemoteAddr = "127.0.0.1:12345"
+		return r
+	}
+	w := httptest.NewRecorder()
+	AdminMux.ServeHTTP(w, local("/features?caller=user&uid=42&flag=beta"))
+	var resp struct {
+		Context     FeatureContext
+		Evaluations []Evaluation
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode failed, err= %v, body= %s", err, w.Body.String())
+	}
+	if len(resp.Evaluations) != 1 || !resp.Evaluations[0].Enabled || resp.Evaluations[0].Reason != ReasonAttributeAllowlist {
+		t.Fatalf("resp= %+v", resp)
+	}
+
+	w = httptest.NewRecorder()
+	AdminMux.ServeHTTP(w, local("/features"))
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Evaluations) != 4 {
+		t.Fatalf("evaluations= %v, want all 4 flags", resp.Evaluations)
+	}
+
+	// 未设置AdminToken时拒绝非本机访问，设置后需携带token
+	w = httptest.NewRecorder()
+	AdminMux.ServeHTTP(w, httptest.NewRequest("GET", "/features", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("code= %v, want remote access forbidden", w.Code)
+	}
+	oldConf := ServiceConf
+	defer func() { ServiceConf = oldConf }()
+	ServiceConf.AdminToken = "secret"
+	w = httptest.NewRecorder()
+	AdminMux.ServeHTTP(w, local("/features"))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("code= %v, want token required", w.Code)
+	}
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/features", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	AdminMux.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("code= %v, want ok with token", w.Code)
+	}
+}
@@ -1,32 +1,62 @@
 package server
 
 import (
-	"code.byted.org/gopkg/pkg/log"
 	"context"
-	"github.com/Carey6918/PikaRPC/client"
+	"google.golang.org/grpc/codes"
 	health "google.golang.org/grpc/health/grpc_health_v1"
-	"time"
+	"google.golang.org/grpc/status"
+	"sync"
 )
 
 // gRPC健康检查，实现了grpc_health_v1.HealthServer接口
-type HealthServerImpl struct{}
+// service为空时表示整个服务进程的状态，TTL检查和HTTP检查也以此为准
+type HealthServerImpl struct {
+	sync.RWMutex
+	statuses map[string]health.HealthCheckResponse_ServingStatus
+}
+
+var GHealthServer = NewHealthServer() // 全局健康状态
+
+func NewHealthServer() *HealthServerImpl {
+	return &HealthServerImpl{
+		statuses: map[string]health.HealthCheckResponse_ServingStatus{
+			"": health.HealthCheckResponse_SERVING,
+		},
+	}
+}
+
+// SetServingStatus 设置service的健康状态，service为空时设置整个服务进程的状态
+func (s *HealthServerImpl) SetServingStatus(service string, serving health.HealthCheckResponse_ServingStatus) {
+	s.Lock()
+	defer s.Unlock()
+	s.statuses[service] = serving
+}
+
+// Serving 整个服务进程是否健康
+func (s *HealthServerImpl) Serving() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.statuses[""] == health.HealthCheckResponse_SERVING
+}
 
 func (s *HealthServerImpl) Check(ctx context.Context, req *health.HealthCheckRequest) (*health.HealthCheckResponse, error) {
-	client.Init(client.WithWatchInterval(10 * time.Second))
-	_, err := client.GetConn(req.GetService())
-	defer client.Close(req.GetService())
-	if err != nil {
-		log.Errorf("health check to %v failed, err= %v", req.GetService(), err)
-		return &health.HealthCheckResponse{
-			Status: health.HealthCheckResponse_NOT_SERVING,
-		}, nil
+	s.RLock()
+	defer s.RUnlock()
+	serving, ok := s.statuses[req.GetService()]
+	if !ok {
+		// 未设置状态的gRPC服务跟随整个服务进程的状态
+		registered := false
+		if GServer != nil {
+			_, registered = GetGRPCServer().GetServiceInfo()[req.GetService()]
+		}
+		if !registered {
+			return nil, status.Errorf(codes.NotFound, "unknown service %v", req.GetService())
+		}
+		serving = s.statuses[""]
 	}
-	log.Infof("health check to %v success", req.GetService())
-	return &health.HealthCheckResponse{
-		Status: health.HealthCheckResponse_SERVING,
-	}, nil
+	return &health.HealthCheckResponse{Status: serving}, nil
 }
 
 func (s *HealthServerImpl) Watch(req *health.HealthCheckRequest, server health.Health_WatchServer) error {
-	return nil
+	return status.Errorf(codes.Unimplemented, "health watch is not supported")
 }
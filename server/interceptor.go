@@ -0,0 +1,23 @@
+package server
+
+import (
+	"context"
+	"google.golang.org/grpc"
+)
+
+// 将多个服务端拦截器串联为一个，按传入顺序由外到内执行
+func chainUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			chained = bindHandler(interceptors[i], info, chained)
+		}
+		return chained(ctx, req)
+	}
+}
+
+func bindHandler(interceptor grpc.UnaryServerInterceptor, info *grpc.UnaryServerInfo, next grpc.UnaryHandler) grpc.UnaryHandler {
+	return func(ctx context.Context, req interface{}) (interface{}, error) {
+		return interceptor(ctx, req, info, next)
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"code.byted.org/gopkg/pkg/log"
+	"os"
+	"sync"
+)
+
+// LogConfig 日志配置，对应service_info.yml中的Log段，SIGHUP时可重新加载
+type LogConfig struct {
+	File      string `yaml:"File"`                                     // 日志文件，为空时输出到标准错误
+	MaxSizeMB int64  `yaml:"MaxSizeMB" default:"200" validate:"min=1"` // 单个日志文件的大小上限
+	MaxRotate int    `yaml:"MaxRotate" default:"10" validate:"min=1"`  // 保留的日志文件个数
+	ShortFile bool   `yaml:"ShortFile"`                                // 是否输出文件名和行号
+}
+
+var (
+	logMu   sync.Mutex
+	logFile *log.RotatedFile // 当前使用的日志文件，切换时关闭
+)
+
+// InitLog 按ServiceConf.Log设置日志输出
+func InitLog() {
+	if err := applyLogConfig(ServiceConf.Log); err != nil {
+		log.Errorf("setup log failed, err= %v", err)
+	}
+}
+
+func applyLogConfig(conf LogConfig) error {
+	logMu.Lock()
+	defer logMu.Unlock()
+	flags := log.LstdFlags
+	if conf.ShortFile {
+		flags |= log.Lshortfile
+	}
+	log.SetFlags(flags)
+
+	old := logFile
+	if conf.File == "" {
+		log.SetOutput(os.Stderr)
+		logFile = nil
+	} else {
+		f, err := log.SetupLog2RotatedFile(conf.File, conf.MaxSizeMB<<20, conf.MaxRotate)
+		if err != nil {
+			return err
+		}
+		logFile = f
+	}
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
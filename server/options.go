@@ -3,7 +3,8 @@ package server
 import "google.golang.org/grpc"
 
 type Option struct {
-	gOpts []grpc.ServerOption
+	gOpts        []grpc.ServerOption
+	interceptors []grpc.UnaryServerInterceptor
 }
 
 type Options func(o *Option)
@@ -13,3 +14,11 @@ func WithGRPCOpts(gOpts ...grpc.ServerOption) Options {
 		o.gOpts = gOpts
 	}
 }
+
+// 追加服务端拦截器，按传入顺序由外到内执行
+// 使用该选项时不要再通过WithGRPCOpts设置grpc.UnaryInterceptor
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Options {
+	return func(o *Option) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"github.com/Carey6918/PikaRPC/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 限流拒绝时通过trailer返回的建议重试间隔，值为time.Duration字符串，如"250ms"
+const RetryAfterKey = "retry-after"
+
+const unknownCaller = "unknown"
+
+// 未配置的调用方各自计数的令牌桶数量上限
+const defaultMaxCallers = 10000
+
+// 不限流的服务，避免consul健康检查被限流后摘除实例
+const healthServicePrefix = "/grpc.health.v1.Health/"
+
+// RateLimitConfig 服务端限流配置，对应service_info.yml中的RateLimit段
+type RateLimitConfig struct {
+	CallerKey     string           `yaml:"CallerKey"`     // 携带调用方身份的metadata key，默认为client.CallerMetadataKey
+	Methods       map[string]Limit `yaml:"Methods"`       // 按方法限流，key为完整方法名，如"/add.AddService/Add"
+	Callers       map[string]Limit `yaml:"Callers"`       // 按调用方限流
+	DefaultCaller Limit            `yaml:"DefaultCaller"` // 未在Callers中配置的调用方，每个调用方独立计数
+	MaxCallers    int              `yaml:"MaxCallers"`    // 独立计数的未配置调用方数量上限，超过时淘汰最久未访问的，默认10000
+}
+
+// Limit 令牌桶参数，QPS<=0表示不限流
+type Limit struct {
+	QPS   float64 `yaml:"QPS"`
+	Burst int     `yaml:"Burst"`
+}
+
+func (l Limit) enabled() bool {
+	return l.QPS > 0
+}
+
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return math.Max(1, math.Ceil(l.QPS))
+}
+
+type tokenBucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit Limit, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		limit:  limit,
+		tokens: limit.burst(),
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.limit.burst(), b.tokens+now.Sub(b.last).Seconds()*b.limit.QPS)
+		b.last = now
+	}
+}
+
+// 距离下一个令牌可用的时间
+func (b *tokenBucket) wait() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit.QPS * float64(time.Second))
+}
+
+// RateLimiter 按方法和调用方两个维度限流，两个维度都有令牌时才放行
+type RateLimiter struct {
+	sync.Mutex
+	conf    RateLimitConfig
+	methods map[string]*tokenBucket
+	callers map[string]*tokenBucket // Callers中配置的调用方
+	others  map[string]*list.Element
+	lru     *list.List // 未配置的调用方，按最近访问排序，元素为*callerBucket
+}
+
+type callerBucket struct {
+	caller string
+	bucket *tokenBucket
+}
+
+func NewRateLimiter(conf RateLimitConfig) *RateLimiter {
+	l := &RateLimiter{}
+	l.Update(conf)
+	return l
+}
+
+// Update 运行时更新限流配置，已有令牌桶会按新配置重建
+func (l *RateLimiter) Update(conf RateLimitConfig) {
+	if conf.CallerKey == "" {
+		conf.CallerKey = client.CallerMetadataKey
+	}
+	if conf.MaxCallers <= 0 {
+		conf.MaxCallers = defaultMaxCallers
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.conf = conf
+	l.methods = make(map[string]*tokenBucket)
+	l.callers = make(map[string]*tokenBucket)
+	l.others = make(map[string]*list.Element)
+	l.lru = list.New()
+}
+
+func (l *RateLimiter) callerLimit(caller string) Limit {
+	if limit, ok := l.conf.Callers[caller]; ok {
+		return limit
+	}
+	return l.conf.DefaultCaller
+}
+
+// 调用方的令牌桶，未配置的调用方数量超过MaxCallers时淘汰最久未访问的
+func (l *RateLimiter) callerBucket(caller string, now time.Time) *tokenBucket {
+	if limit, ok := l.conf.Callers[caller]; ok {
+		return bucketFor(l.callers, caller, limit, now)
+	}
+	if !l.conf.DefaultCaller.enabled() {
+		return nil
+	}
+	if e, ok := l.others[caller]; ok {
+		l.lru.MoveToFront(e)
+		b := e.Value.(*callerBucket).bucket
+		b.refill(now)
+		return b
+	}
+	for l.lru.Len() >= l.conf.MaxCallers {
+		oldest := l.lru.Back()
+		l.lru.Remove(oldest)
+		delete(l.others, oldest.Value.(*callerBucket).caller)
+	}
+	b := newTokenBucket(l.conf.DefaultCaller, now)
+	l.others[caller] = l.lru.PushFront(&callerBucket{caller: caller, bucket: b})
+	return b
+}
+
+func bucketFor(buckets map[string]*tokenBucket, key string, limit Limit, now time.Time) *tokenBucket {
+	if !limit.enabled() {
+		return nil
+	}
+	b, ok := buckets[key]
+	if !ok {
+		b = newTokenBucket(limit, now)
+		buckets[key] = b
+	}
+	b.refill(now)
+	return b
+}
+
+// allow 判断请求是否放行，拒绝时返回建议的重试间隔
+func (l *RateLimiter) allow(method, caller string, now time.Time) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	var retryAfter time.Duration
+	buckets := []*tokenBucket{
+		bucketFor(l.methods, method, l.conf.Methods[method], now),
+		l.callerBucket(caller, now),
+	}
+	for _, b := range buckets {
+		if b == nil {
+			continue
+		}
+		if wait := b.wait(); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+	for _, b := range buckets {
+		if b != nil {
+			b.tokens--
+		}
+	}
+	return true, 0
+}
+
+// CallerKey 携带调用方身份的metadata key
+func (l *RateLimiter) CallerKey() string {
+	l.Lock()
+	defer l.Unlock()
+	return l.conf.CallerKey
+}
+
+func (l *RateLimiter) caller(ctx context.Context) string {
+	key := l.CallerKey()
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(key); len(values) > 0 && values[0] != "" {
+			return values[0]
+		}
+	}
+	return unknownCaller
+}
+
+// UnaryInterceptor 限流拦截器，被限流时返回ResourceExhausted，并在trailer中携带retry-after
+// gRPC健康检查不限流
+func (l *RateLimiter) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+		return handler(ctx, req)
+	}
+	caller := l.caller(ctx)
+	if ok, retryAfter := l.allow(info.FullMethod, caller, time.Now()); !ok {
+		grpc.SetTrailer(ctx, metadata.Pairs(RetryAfterKey, retryAfter.String()))
+		return nil, status.Errorf(codes.ResourceExhausted, "rate limited, method= %v, caller= %v, retry after %v", info.FullMethod, caller, retryAfter)
+	}
+	return handler(ctx, req)
+}
+
+var GRateLimiter *RateLimiter // 全局限流器
+
+// SetRateLimit 运行时更新全局限流配置
+func SetRateLimit(conf RateLimitConfig) {
+	GRateLimiter.Update(conf)
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{
+		Methods:       map[string]Limit{"/add.AddService/Add": {QPS: 10, Burst: 2}},
+		Callers:       map[string]Limit{"vip": {QPS: 1000}},
+		DefaultCaller: Limit{QPS: 1, Burst: 1},
+	})
+	now := time.Now()
+
+	if ok, _ := l.allow("/add.AddService/Add", "vip", now); !ok {
+		t.Fatalf("first request should be allowed")
+	}
+	if ok, _ := l.allow("/add.AddService/Add", "vip", now); !ok {
+		t.Fatalf("second request should be allowed by burst")
+	}
+	ok, retryAfter := l.allow("/add.AddService/Add", "vip", now)
+	if ok {
+		t.Fatalf("third request should be limited by method")
+	}
+	if retryAfter != 100*time.Millisecond {
+		t.Fatalf("retryAfter= %v, want 100ms", retryAfter)
+	}
+	if ok, _ := l.allow("/add.AddService/Add", "vip", now.Add(retryAfter)); !ok {
+		t.Fatalf("request after retryAfter should be allowed")
+	}
+
+	if ok, _ := l.allow("/add.AddService/Other", "guest", now); !ok {
+		t.Fatalf("first guest request should be allowed")
+	}
+	if ok, _ := l.allow("/add.AddService/Other", "guest", now); ok {
+		t.Fatalf("second guest request should be limited by caller")
+	}
+	if ok, _ := l.allow("/add.AddService/Other", "another", now); !ok {
+		t.Fatalf("callers should be counted separately")
+	}
+}
+
+func TestRateLimiterUpdate(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{DefaultCaller: Limit{QPS: 1, Burst: 1}})
+	now := time.Now()
+	l.allow("/m", "c", now)
+	if ok, _ := l.allow("/m", "c", now); ok {
+		t.Fatalf("request should be limited")
+	}
+	l.Update(RateLimitConfig{})
+	if ok, _ := l.allow("/m", "c", now); !ok {
+		t.Fatalf("request should be allowed after limit removed")
+	}
+}
+
+func TestRateLimiterMaxCallers(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{
+		Callers:       map[string]Limit{"vip": {QPS: 1, Burst: 1}},
+		DefaultCaller: Limit{QPS: 1, Burst: 1},
+		MaxCallers:    2,
+	})
+	now := time.Now()
+	l.allow("/m", "vip", now)
+	for _, caller := range []string{"a", "b", "c", "d"} {
+		l.allow("/m", caller, now)
+	}
+	if len(l.others) != 2 || l.lru.Len() != 2 {
+		t.Fatalf("callers= %v, want at most 2", len(l.others))
+	}
+	if _, ok := l.others["a"]; ok {
+		t.Fatalf("least recently used caller should be evicted")
+	}
+	// 配置的调用方不会被淘汰
+	if ok, _ := l.allow("/m", "vip", now); ok {
+		t.Fatalf("configured caller should keep its bucket")
+	}
+}
+
+func TestRateLimiterSkipHealth(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{DefaultCaller: Limit{QPS: 1, Burst: 1}})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil }
+	for i := 0; i < 3; i++ {
+		info := &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+		if _, err := l.UnaryInterceptor(context.Background(), nil, info, handler); err != nil {
+			t.Fatalf("health check should not be limited, err= %v", err)
+		}
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/add.AddService/Add"}
+	l.UnaryInterceptor(context.Background(), nil, info, handler)
+	if _, err := l.UnaryInterceptor(context.Background(), nil, info, handler); err == nil {
+		t.Fatalf("request without caller should be limited")
+	}
+}
@@ -3,58 +3,206 @@ package server
 import (
 	"code.byted.org/gopkg/pkg/log"
 	"fmt"
+	"github.com/Carey6918/PikaRPC/client"
 	"github.com/Carey6918/PikaRPC/helper"
 	consul "github.com/hashicorp/consul/api"
+	"os"
+	"sort"
+	"strings"
 	"time"
 )
 
 /**
-	使用consul进行服务发现与服务注册
-	https://godoc.org/github.com/hashicorp/consul/api#pkg-index
-  */
-
-const consulPort  = "8500"
+使用consul进行服务发现与服务注册
+https://godoc.org/github.com/hashicorp/consul/api#pkg-index
+*/
 
 type RegisterContext struct {
 	ServiceName                    string
 	Tags                           []string
+	Meta                           map[string]string
+	Address                        string
 	Port                           int
 	DeregisterCriticalServiceAfter time.Duration
 	Interval                       time.Duration
+	Checks                         []CheckConfig
 }
 
 func NewRegisterContest() *RegisterContext {
-	return &RegisterContext{
-		ServiceName: ServiceConf.ServiceName,
-		Tags:        []string{},
-		Port:        helper.S2I(ServiceConf.ServicePort),
+	return newRegisterContext(GetServiceConf())
+}
+
+func newRegisterContext(conf ServiceConfig) *RegisterContext {
+	address, port := advertised()
+	r := &RegisterContext{
+		ServiceName:                    conf.ServiceName,
+		Tags:                           append([]string{}, conf.Tags...),
+		Meta:                           registerMeta(conf),
+		Address:                        address,
+		Port:                           port,
 		DeregisterCriticalServiceAfter: 1 * time.Minute,
 		Interval:                       10 * time.Second,
 	}
+	checks := conf.Checks
+	if len(checks) == 0 {
+		checks = []CheckConfig{{Type: CheckGRPC}}
+	}
+	for _, check := range checks {
+		r.Checks = append(r.Checks, check.withDefaults(r))
+	}
+	return r
+}
+
+// 按GRPCServices的配置方式
+const (
+	RegisterByService = "service" // 每个gRPC服务注册为单独的consul服务
+	RegisterByTag     = "tag"     // gRPC服务全名作为tag加到ServiceName上
+)
+
+// GRPCServicesConfig 按gRPC服务注册，对应service_info.yml中的GRPCServices段
+type GRPCServicesConfig struct {
+	Mode    string            `yaml:"Mode"`    // 为空时只注册ServiceName，或为service、tag
+	Mapping map[string]string `yaml:"Mapping"` // gRPC服务全名 -> consul服务名，设置时只注册其中的gRPC服务
+}
+
+func (c GRPCServicesConfig) validate() error {
+	switch c.Mode {
+	case "", RegisterByTag:
+		return nil
+	case RegisterByService:
+		// 多个gRPC服务映射到同一个consul服务名时注册ID相同，后注册的会覆盖前面的
+		grpcNames := make([]string, 0, len(c.Mapping))
+		for name := range c.Mapping {
+			grpcNames = append(grpcNames, name)
+		}
+		sort.Strings(grpcNames)
+		seen := make(map[string]string)
+		for _, name := range grpcNames {
+			service := c.Mapping[name]
+			if other, ok := seen[service]; ok {
+				return fmt.Errorf("GRPCServices: %v and %v are both mapped to %q", other, name, service)
+			}
+			seen[service] = name
+		}
+		return nil
+	}
+	return fmt.Errorf("GRPCServices: unknown mode %q", c.Mode)
+}
+
+// 需要注册的gRPC服务全名 -> consul服务名，不包括健康检查、反射等框架内置的服务
+func grpcServiceNames(conf ServiceConfig) map[string]string {
+	if mapping := conf.GRPCServices.Mapping; len(mapping) > 0 {
+		return mapping
+	}
+	names := make(map[string]string)
+	for name := range GetGRPCServer().GetServiceInfo() {
+		if !strings.HasPrefix(name, "grpc.") {
+			names[name] = name
+		}
+	}
+	return names
+}
+
+// 按GRPCServices的配置生成所有需要注册的consul服务
+func registrations() []*consul.AgentServiceRegistration {
+	conf := GetServiceConf()
+	r := newRegisterContext(conf)
+	names := make(map[string]string)
+	if conf.GRPCServices.Mode != "" {
+		names = grpcServiceNames(conf)
+	}
+	grpcNames := make([]string, 0, len(names))
+	for name := range names {
+		grpcNames = append(grpcNames, name)
+	}
+	sort.Strings(grpcNames)
+
+	switch conf.GRPCServices.Mode {
+	case RegisterByService:
+		// 还没有注册任何gRPC服务时退回注册ServiceName
+		if len(grpcNames) == 0 {
+			break
+		}
+		regs := make([]*consul.AgentServiceRegistration, 0, len(grpcNames))
+		for _, name := range grpcNames {
+			service := *r
+			service.ServiceName = names[name]
+			service.Checks = make([]CheckConfig, 0, len(r.Checks))
+			for _, check := range r.Checks {
+				// gRPC检查默认检查对应的gRPC服务
+				if check.Type == CheckGRPC && check.GRPCService == "" {
+					check.GRPCService = name
+				}
+				service.Checks = append(service.Checks, check)
+			}
+			regs = append(regs, service.registration())
+		}
+		return regs
+	case RegisterByTag:
+		r.Tags = append(r.Tags, grpcNames...)
+	}
+	return []*consul.AgentServiceRegistration{r.registration()}
+}
+
+// 注册到consul，失败时在后台重试，并定期检查注册信息
+func startRegister() {
+	consulClient, err := newConsulClient()
+	if err != nil {
+		log.Fatalf("consul new client failed, err= %v", err)
+	}
+	GRegistrar = NewRegistrar(ServiceConf.Register, consulClient.Agent(), registrations)
+	GRegistrar.Start()
+	startHeartbeats(consulClient.Agent(), registrations(), GServer.done)
+}
+
+// 按ServiceConf.Consul连接consul agent
+func newConsulClient() (*consul.Client, error) {
+	return GetServiceConf().Consul.NewClient()
 }
 
 func (r *RegisterContext) Register() error {
-	config := consul.DefaultConfig()
-	config.Address = helper.GetLocalAddress(consulPort)
-	client, err := consul.NewClient(config)
+	client, err := newConsulClient()
 	if err != nil {
-		log.Fatal("consul new client failed, err= %v", err)
+		log.Errorf("consul new client failed, err= %v", err)
 		return err
 	}
-	agent := client.Agent()
-	localIP := helper.GetLocalIP()
+	return client.Agent().ServiceRegister(r.registration())
+}
+
+func (r *RegisterContext) registration() *consul.AgentServiceRegistration {
 	registration := &consul.AgentServiceRegistration{
 		ID:      r.ServiceName,
 		Name:    r.ServiceName,
 		Tags:    r.Tags,
+		Meta:    r.Meta,
 		Port:    r.Port,
-		Address: localIP,
-		//todo 健康检查的编码还没完成，服务在1分钟后会挂掉，所以先注释了
-		Check: &consul.AgentServiceCheck{ // 开启健康检查
-			GRPC:                           fmt.Sprintf("%v:%v/%v", localIP, r.Port, r.ServiceName), //grpc 支持，执行健康检查的地址，service 会传到 Health.Check 函数中
-			Interval:                       r.Interval.String(),                                     // 健康检查间隔，默认为10s
-			DeregisterCriticalServiceAfter: r.DeregisterCriticalServiceAfter.String(),               // 如果检查超过这个时间，那么会自动注销这个注册
-		},
-	}
-	return agent.ServiceRegister(registration)
+		Address: r.Address,
+	}
+	for _, check := range r.Checks {
+		registration.Checks = append(registration.Checks, check.agentCheck(r))
+	}
+	return registration
+}
+
+// 随服务注册到consul的Meta信息
+func registerMeta(conf ServiceConfig) map[string]string {
+	meta := make(map[string]string)
+	for k, v := range conf.Meta {
+		meta[k] = v
+	}
+	zone := conf.Zone
+	if zone == "" {
+		zone = os.Getenv(client.ZoneEnv)
+	}
+	if zone != "" {
+		meta[client.ZoneMetaKey] = zone
+	}
+	if len(conf.Shards) > 0 {
+		shards := make([]string, 0, len(conf.Shards))
+		for _, shard := range conf.Shards {
+			shards = append(shards, helper.I2S(shard))
+		}
+		meta[client.ShardsMetaKey] = strings.Join(shards, ",")
+	}
+	return meta
 }
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"google.golang.org/grpc"
+	"strings"
+	"testing"
+)
+
+func registerTestService(name string) {
+	GetGRPCServer().RegisterService(&grpc.ServiceDesc{ServiceName: name, HandlerType: (*interface{})(nil)}, struct{}{})
+}
+
+func TestRegistrationsByGRPCService(t *testing.T) {
+	oldConf, oldServer := ServiceConf, GServer
+	defer func() { ServiceConf, GServer = oldConf, oldServer }()
+	ServiceConf = ServiceConfig{ServiceName: "calc", ServicePort: "9785", Tags: []string{"canary"}}
+	NewServer()
+	registerTestService("add.AddService")
+	registerTestService("sub.SubService")
+	registerTestService("grpc.health.v1.Health")
+
+	regs := registrations()
+	if len(regs) != 1 || regs[0].Name != "calc" || len(regs[0].Tags) != 1 {
+		t.Fatalf("default mode should register ServiceName only, regs= %+v", regs[0])
+	}
+
+	ServiceConf.GRPCServices.Mode = RegisterByTag
+	regs = registrations()
+	if len(regs) != 1 || len(regs[0].Tags) != 3 || regs[0].Tags[1] != "add.AddService" || regs[0].Tags[2] != "sub.SubService" {
+		t.Fatalf("tags= %v", regs[0].Tags)
+	}
+
+	ServiceConf.GRPCServices.Mode = RegisterByService
+	regs = registrations()
+	if len(regs) != 2 || regs[0].ID != "add.AddService" || regs[1].ID != "sub.SubService" {
+		t.Fatalf("regs= %+v", regs)
+	}
+	if check := regs[0].Checks[0]; check.CheckID != "service:add.AddService:grpc" || !strings.HasSuffix(check.GRPC, "/add.AddService") {
+		t.Fatalf("check= %+v, want per-service grpc check", check)
+	}
+
+	ServiceConf.GRPCServices.Mapping = map[string]string{"add.AddService": "adder"}
+	regs = registrations()
+	if len(regs) != 1 || regs[0].Name != "adder" {
+		t.Fatalf("regs= %+v, want mapped service only", regs)
+	}
+}
+
+func TestGRPCServicesValidate(t *testing.T) {
+	conf := GRPCServicesConfig{Mode: RegisterByService, Mapping: map[string]string{"add.AddService": "calc", "sub.SubService": "calc"}}
+	if err := conf.validate(); err == nil {
+		t.Fatalf("should reject grpc services mapped to the same consul service")
+	}
+	conf.Mapping["sub.SubService"] = "subber"
+	if err := conf.validate(); err != nil {
+		t.Fatalf("err= %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"code.byted.org/gopkg/pkg/log"
+	"fmt"
+	"github.com/armon/go-metrics"
+	consul "github.com/hashicorp/consul/api"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// RegisterConfig 注册重试与反熵配置，对应service_info.yml中的Register段
+type RegisterConfig struct {
+	MinBackoff   time.Duration `yaml:"MinBackoff" default:"200ms"` // 注册失败后首次重试的间隔
+	MaxBackoff   time.Duration `yaml:"MaxBackoff" default:"30s"`   // 重试间隔的上限
+	SyncInterval time.Duration `yaml:"SyncInterval" default:"30s"` // 检查agent上注册信息的间隔
+}
+
+func (c RegisterConfig) withDefaults() RegisterConfig {
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = 200 * time.Millisecond
+	}
+	if c.MaxBackoff < c.MinBackoff {
+		c.MaxBackoff = 30 * time.Second
+	}
+	if c.SyncInterval <= 0 {
+		c.SyncInterval = 30 * time.Second
+	}
+	return c
+}
+
+// Registrar用到的consul agent接口
+type serviceAgent interface {
+	ServiceRegister(service *consul.AgentServiceRegistration) error
+	ServiceDeregister(serviceID string) error
+	Services() (map[string]*consul.AgentService, error)
+	Checks() (map[string]*consul.AgentCheck, error)
+}
+
+// Registrar 将服务注册到consul并持续保持：
+// 注册失败时按指数退避重试，注册成功后定期检查agent上的服务和检查项，
+// agent重启丢失注册或注册信息与期望不一致时重新注册
+type Registrar struct {
+	sync.Mutex
+	conf       RegisterConfig
+	agent      serviceAgent
+	build      func() []*consul.AgentServiceRegistration // 每次注册时重新生成，使用最新的ServiceConf
+	registered bool
+	owned      map[string]bool // 本进程注册过的服务ID，只在sync中访问
+	trigger    chan struct{}
+	done       chan struct{}
+}
+
+var GRegistrar *Registrar // 全局注册器
+
+func NewRegistrar(conf RegisterConfig, agent serviceAgent, build func() []*consul.AgentServiceRegistration) *Registrar {
+	return &Registrar{
+		conf:    conf.withDefaults(),
+		agent:   agent,
+		build:   build,
+		owned:   make(map[string]bool),
+		trigger: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start 同步注册一次，失败时不阻塞启动，由后台循环继续重试
+func (g *Registrar) Start() {
+	if err := g.sync(); err != nil {
+		log.Errorf("consul register failed, will retry in background, err= %v", err)
+	}
+	go g.run()
+}
+
+// Stop 停止后台循环
+func (g *Registrar) Stop() {
+	close(g.done)
+}
+
+// Sync 立即检查并在需要时重新注册，如配置重新加载后
+func (g *Registrar) Sync() {
+	select {
+	case g.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Registered 服务当前是否已注册到consul
+func (g *Registrar) Registered() bool {
+	g.Lock()
+	defer g.Unlock()
+	return g.registered
+}
+
+func (g *Registrar) run() {
+	backoff := g.conf.MinBackoff
+	for {
+		wait := g.conf.SyncInterval
+		if !g.Registered() {
+			wait = backoff
+			if backoff *= 2; backoff > g.conf.MaxBackoff {
+				backoff = g.conf.MaxBackoff
+			}
+		} else {
+			backoff = g.conf.MinBackoff
+		}
+		select {
+		case <-time.After(wait):
+		case <-g.trigger:
+		case <-g.done:
+			return
+		}
+		g.sync()
+	}
+}
+
+// 检查agent上的注册信息，缺失或不一致时重新注册，并注销本进程注册过、但不再需要的服务
+func (g *Registrar) sync() error {
+	registered := g.Registered()
+	regs := g.build()
+	for _, reg := range regs {
+		if err := g.syncOne(reg, registered); err != nil {
+			g.setState(false, err)
+			return err
+		}
+	}
+	g.setState(true, nil)
+	g.deregisterStale(regs)
+	return nil
+}
+
+// 注销本进程注册过、但不在当前注册列表中的服务，如按gRPC服务注册后不再需要的ServiceName
+// 注册列表不变时没有需要注销的服务，不会访问agent；注销失败时在下次sync重试
+func (g *Registrar) deregisterStale(regs []*consul.AgentServiceRegistration) {
+	wanted := make(map[string]bool, len(regs))
+	for _, reg := range regs {
+		wanted[reg.ID] = true
+	}
+	for id := range g.owned {
+		if wanted[id] {
+			continue
+		}
+		if err := g.agent.ServiceDeregister(id); err != nil {
+			log.Warnf("consul deregister stale service %v failed, err= %v", id, err)
+			continue
+		}
+		delete(g.owned, id)
+		log.Infof("consul deregistered stale service %v", id)
+	}
+}
+
+func (g *Registrar) syncOne(reg *consul.AgentServiceRegistration, registered bool) error {
+	if registered {
+		missing, err := g.drift(reg)
+		if err != nil {
+			return err
+		}
+		if missing == "" {
+			return nil
+		}
+		metrics.IncrCounter([]string{"server", "register", "reconcile"}, 1)
+		log.Warnf("consul registration of %v drifted (%v), re-register", reg.ID, missing)
+	}
+	metrics.IncrCounter([]string{"server", "register", "attempt"}, 1)
+	if err := g.agent.ServiceRegister(reg); err != nil {
+		metrics.IncrCounter([]string{"server", "register", "failure"}, 1)
+		return err
+	}
+	g.owned[reg.ID] = true
+	return nil
+}
+
+// 返回agent上的注册信息与期望不一致的原因，一致时返回空字符串
+func (g *Registrar) drift(reg *consul.AgentServiceRegistration) (string, error) {
+	services, err := g.agent.Services()
+	if err != nil {
+		return "", err
+	}
+	service, ok := services[reg.ID]
+	if !ok {
+		return "service missing", nil
+	}
+	if !sameStrings(service.Tags, reg.Tags) || !sameMeta(service.Meta, reg.Meta) ||
+		service.Port != reg.Port || service.Address != reg.Address {
+		return "service changed", nil
+	}
+	checks, err := g.agent.Checks()
+	if err != nil {
+		return "", err
+	}
+	for _, id := range checkIDs(reg) {
+		if _, ok := checks[id]; !ok {
+			return fmt.Sprintf("check %v missing", id), nil
+		}
+	}
+	return "", nil
+}
+
+func (g *Registrar) setState(registered bool, err error) {
+	g.Lock()
+	changed := g.registered != registered
+	g.registered = registered
+	g.Unlock()
+
+	var gauge float32
+	if registered {
+		gauge = 1
+	}
+	metrics.SetGauge([]string{"server", "register", "registered"}, gauge)
+	if !changed {
+		return
+	}
+	if registered {
+		log.Infof("consul registered")
+	} else {
+		log.Errorf("consul registration lost, err= %v", err)
+	}
+}
+
+// agent为服务检查项生成的ID，与consul agent的规则一致
+func checkIDs(reg *consul.AgentServiceRegistration) []string {
+	var checks consul.AgentServiceChecks
+	if reg.Check != nil {
+		checks = append(checks, reg.Check)
+	}
+	checks = append(checks, reg.Checks...)
+	ids := make([]string, 0, len(checks))
+	for i, check := range checks {
+		id := "service:" + reg.ID
+		if len(checks) > 1 {
+			id = fmt.Sprintf("service:%s:%d", reg.ID, i+1)
+		}
+		if check.CheckID != "" {
+			id = check.CheckID
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func sameStrings(a, b []string) bool {
+	return len(a) == len(b) && (len(a) == 0 || reflect.DeepEqual(a, b))
+}
+
+func sameMeta(a, b map[string]string) bool {
+	return len(a) == len(b) && (len(a) == 0 || reflect.DeepEqual(a, b))
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"errors"
+	consul "github.com/hashicorp/consul/api"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 模拟consul agent，down时所有请求失败
+type fakeAgent struct {
+	sync.Mutex
+	down      bool
+	registers int
+	services  map[string]*consul.AgentService
+	checks    map[string]*consul.AgentCheck
+}
+
+func newFakeAgent() *fakeAgent {
+	return &fakeAgent{services: make(map[string]*consul.AgentService), checks: make(map[string]*consul.AgentCheck)}
+}
+
+func (a *fakeAgent) ServiceRegister(reg *consul.AgentServiceRegistration) error {
+	a.Lock()
+	defer a.Unlock()
+	if a.down {
+		return errors.New("connection refused")
+	}
+	a.registers++
+	a.services[reg.ID] = &consul.AgentService{ID: reg.ID, Service: reg.Name, Tags: reg.Tags, Meta: reg.Meta, Port: reg.Port, Address: reg.Address}
+	for _, id := range checkIDs(reg) {
+		a.checks[id] = &consul.AgentCheck{CheckID: id}
+	}
+	return nil
+}
+
+func (a *fakeAgent) ServiceDeregister(serviceID string) error {
+	a.Lock()
+	defer a.Unlock()
+	if a.down {
+		return errors.New("connection refused")
+	}
+	delete(a.services, serviceID)
+	return nil
+}
+
+func (a *fakeAgent) Services() (map[string]*consul.AgentService, error) {
+	a.Lock()
+	defer a.Unlock()
+	if a.down {
+		return nil, errors.New("connection refused")
+	}
+	return a.services, nil
+}
+
+func (a *fakeAgent) Checks() (map[string]*consul.AgentCheck, error) {
+	a.Lock()
+	defer a.Unlock()
+	if a.down {
+		return nil, errors.New("connection refused")
+	}
+	return a.checks, nil
+}
+
+// agent重启，丢失所有注册信息
+func (a *fakeAgent) restart(down bool) {
+	a.Lock()
+	defer a.Unlock()
+	a.down = down
+	a.services = make(map[string]*consul.AgentService)
+	a.checks = make(map[string]*consul.AgentCheck)
+}
+
+func TestRegistrarReconcile(t *testing.T) {
+	agent := newFakeAgent()
+	tags := []string{"v1"}
+	g := NewRegistrar(RegisterConfig{}, agent, func() []*consul.AgentServiceRegistration {
+		return []*consul.AgentServiceRegistration{
+			{ID: "add", Name: "add", Tags: tags, Port: 9785, Check: &consul.AgentServiceCheck{TTL: "10s"}},
+			{ID: "sub", Name: "sub", Port: 9785},
+		}
+	})
+
+	if err := g.sync(); err != nil || !g.Registered() {
+		t.Fatalf("first sync failed, err= %v", err)
+	}
+	g.sync()
+	if agent.registers != 2 {
+		t.Fatalf("registers= %v, want no re-register when consistent", agent.registers)
+	}
+
+	agent.restart(true)
+	if err := g.sync(); err == nil || g.Registered() {
+		t.Fatalf("sync should fail while agent is down")
+	}
+	agent.restart(false)
+	if err := g.sync(); err != nil || !g.Registered() || agent.registers != 4 {
+		t.Fatalf("should re-register after agent restart, err= %v, registers= %v", err, agent.registers)
+	}
+
+	delete(agent.checks, "service:add")
+	g.sync()
+	if agent.registers != 5 {
+		t.Fatalf("should re-register when check is missing, registers= %v", agent.registers)
+	}
+
+	tags = []string{"v2"}
+	g.sync()
+	if agent.registers != 6 || agent.services["add"].Tags[0] != "v2" {
+		t.Fatalf("should re-register when tags changed, registers= %v", agent.registers)
+	}
+}
+
+// 注册方式变化后注销本进程之前的注册，不影响其他进程在同一地址上的注册
+func TestRegistrarDeregisterStale(t *testing.T) {
+	agent := newFakeAgent()
+	agent.services["other"] = &consul.AgentService{ID: "other", Address: "10.0.0.1", Port: 9785}
+	ids := []string{"calc"}
+	g := NewRegistrar(RegisterConfig{}, agent, func() []*consul.AgentServiceRegistration {
+		var regs []*consul.AgentServiceRegistration
+		for _, id := range ids {
+			regs = append(regs, &consul.AgentServiceRegistration{ID: id, Name: id, Address: "10.0.0.1", Port: 9785})
+		}
+		return regs
+	})
+	g.sync()
+	ids = []string{"adder", "subber"}
+	if err := g.sync(); err != nil {
+		t.Fatalf("sync failed, err= %v", err)
+	}
+	if _, ok := agent.services["calc"]; ok || len(agent.services) != 3 {
+		t.Fatalf("services= %v, want calc deregistered and other kept", agent.services)
+	}
+}
+
+func TestRegistrarRetry(t *testing.T) {
+	agent := newFakeAgent()
+	agent.down = true
+	g := NewRegistrar(RegisterConfig{MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}, agent, func() []*consul.AgentServiceRegistration {
+		return []*consul.AgentServiceRegistration{{ID: "add", Name: "add"}}
+	})
+	g.Start()
+	defer g.Stop()
+	time.Sleep(20 * time.Millisecond)
+	if g.Registered() {
+		t.Fatalf("should not be registered while agent is down")
+	}
+	agent.restart(false)
+	deadline := time.Now().Add(time.Second)
+	for !g.Registered() {
+		if time.Now().After(deadline) {
+			t.Fatalf("should register after agent recovers")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCheckIDs(t *testing.T) {
+	reg := &consul.AgentServiceRegistration{ID: "add", Checks: consul.AgentServiceChecks{{TTL: "10s"}, {CheckID: "custom", TCP: "127.0.0.1:9785"}}}
+	ids := checkIDs(reg)
+	if len(ids) != 2 || ids[0] != "service:add:1" || ids[1] != "custom" {
+		t.Fatalf("ids= %v", ids)
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"code.byted.org/gopkg/pkg/log"
+	"github.com/armon/go-metrics"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+// ReloadFunc 配置重新加载后的回调，old和new分别为加载前后的配置，返回的错误只记录日志
+type ReloadFunc func(old, new ServiceConfig) error
+
+var (
+	reloadMu    sync.Mutex
+	reloadFuncs []ReloadFunc
+	reloading   sync.Mutex // 保证同一时间只有一次Reload
+	pending     []string   // 已修改、需要重启才能生效的配置项，reloading保护
+)
+
+// OnReload 注册配置重新加载后的回调，如应用自己的配置段需要随SIGHUP更新时使用
+func OnReload(fn ReloadFunc) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloadFuncs = append(reloadFuncs, fn)
+}
+
+// 修改后需要重启才能生效的配置项，检测变化和还原都按这张表进行
+// fields返回配置项包含的字段的指针
+var restartFields = []struct {
+	name   string
+	fields func(c *ServiceConfig) []interface{}
+}{
+	{"ServiceName", func(c *ServiceConfig) []interface{} { return []interface{}{&c.ServiceName} }},
+	{"ServicePort", func(c *ServiceConfig) []interface{} { return []interface{}{&c.ServicePort} }},
+	{"ConfigPrefix", func(c *ServiceConfig) []interface{} { return []interface{}{&c.ConfigPrefix} }},
+	{"Features", func(c *ServiceConfig) []interface{} { return []interface{}{&c.Features} }},
+	{"AdminPort", func(c *ServiceConfig) []interface{} { return []interface{}{&c.AdminPort} }},
+	{"Consul", func(c *ServiceConfig) []interface{} { return []interface{}{&c.Consul} }},
+	{"Register", func(c *ServiceConfig) []interface{} { return []interface{}{&c.Register} }},
+	{"Checks", func(c *ServiceConfig) []interface{} { return []interface{}{&c.Checks} }},
+	{"GRPCServices", func(c *ServiceConfig) []interface{} { return []interface{}{&c.GRPCServices} }},
+	{"BindAddress", func(c *ServiceConfig) []interface{} { return []interface{}{&c.BindAddress} }},
+	{"AdvertiseAddress", func(c *ServiceConfig) []interface{} {
+		return []interface{}{&c.AdvertiseAddress, &c.AdvertiseAddressEnv, &c.AdvertiseInterface}
+	}},
+	{"IP", func(c *ServiceConfig) []interface{} { return []interface{}{&c.IP} }},
+}
+
+// 修改后需要重启才能生效的配置项
+func restartRequired(old, new ServiceConfig) []string {
+	var fields []string
+	for _, f := range restartFields {
+		if !reflect.DeepEqual(f.fields(&old), f.fields(&new)) {
+			fields = append(fields, f.name)
+		}
+	}
+	return fields
+}
+
+// 将需要重启才能生效的配置项还原为运行中的值
+func keepRestartFields(conf *ServiceConfig, running ServiceConfig) {
+	for _, f := range restartFields {
+		src := f.fields(&running)
+		for i, dst := range f.fields(conf) {
+			reflect.ValueOf(dst).Elem().Set(reflect.ValueOf(src[i]).Elem())
+		}
+	}
+}
+
+// 注册到consul的信息是否变化
+func registrationChanged(old, new ServiceConfig) bool {
+	return !reflect.DeepEqual(old.Tags, new.Tags) || !reflect.DeepEqual(old.Meta, new.Meta) ||
+		old.Zone != new.Zone || !reflect.DeepEqual(old.Shards, new.Shards)
+}
+
+// Reload 重新加载service_info.yml并应用可以在线生效的配置
+// 加载或校验失败时保留原配置；需要重启才能生效的配置项保持原值，返回这些配置项的名称
+func Reload() ([]string, error) {
+	reloading.Lock()
+	defer reloading.Unlock()
+
+	old := GetServiceConf()
+	conf, err := loadServiceConfig()
+	if err != nil {
+		log.Errorf("reload config failed, keep old config, err= %v", err)
+		return nil, err
+	}
+	restart := restartRequired(old, conf)
+	if len(restart) > 0 {
+		log.Warnf("config %v changed, restart required to take effect", restart)
+		// 保持运行中的值，避免注册信息与实际监听的端口不一致
+		keepRestartFields(&conf, old)
+	}
+	// 还原需要重启的配置项后再一次性发布
+	setServiceConf(conf)
+	pending = restart
+	metrics.SetGauge([]string{"server", "config", "restart_required"}, float32(len(restart)))
+
+	if registrationChanged(old, conf) && GRegistrar != nil {
+		log.Infof("registration changed, tags= %v, meta= %v", conf.Tags, registerMeta(conf))
+		GRegistrar.Sync()
+	}
+	if old.Log != conf.Log {
+		if err := applyLogConfig(conf.Log); err != nil {
+			log.Errorf("reload log config failed, err= %v", err)
+		}
+	}
+	if GRateLimiter != nil && !reflect.DeepEqual(old.RateLimit, conf.RateLimit) {
+		GRateLimiter.Update(conf.RateLimit)
+	}
+	if GConcurrencyLimiter != nil && old.Concurrency != conf.Concurrency {
+		GConcurrencyLimiter.Update(conf.Concurrency)
+	}
+	if DynConf != nil {
+		if err := DynConf.loadLocal(configFiles()...); err != nil {
+			log.Errorf("reload dynamic config defaults failed, err= %v", err)
+		}
+	}
+	reloadMu.Lock()
+	funcs := append([]ReloadFunc{}, reloadFuncs...)
+	reloadMu.Unlock()
+	for _, fn := range funcs {
+		if err := fn(old, conf); err != nil {
+			log.Errorf("reload callback failed, err= %v", err)
+		}
+	}
+	log.Infof("config reloaded")
+	return restart, nil
+}
+
+// PendingRestart 返回最近一次Reload中已修改、需要重启才能生效的配置项
+func PendingRestart() []string {
+	reloading.Lock()
+	defer reloading.Unlock()
+	return append([]string{}, pending...)
+}
+
+// 返回需要重启才能生效的配置项，如 /config/restart
+func restartHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, struct {
+		RestartRequired []string `json:"restart_required"`
+	}{PendingRestart()})
+}
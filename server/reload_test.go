@@ -0,0 +1,102 @@
+package server
+
+import (
+	"github.com/Carey6918/PikaRPC/helper"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	defer writeConfigFiles(t, map[string]string{
+		"service_info.yml": "ServiceName: add\nServicePort: \"9785\"\nRateLimit: {DefaultCaller: {QPS: 100}}\n",
+	})()
+	if err := LoadServiceConfig(); err != nil {
+		t.Fatalf("load failed, err= %v", err)
+	}
+	GRateLimiter = NewRateLimiter(ServiceConf.RateLimit)
+	defer func() { GRateLimiter = nil }()
+
+	var called int
+	OnReload(func(old, new ServiceConfig) error {
+		called++
+		return nil
+	})
+	defer func() { reloadFuncs = nil }()
+
+	file := filepath.Join(ConfigDir, ServiceConfigFile)
+	ioutil.WriteFile(file, []byte("ServiceName: add\nServicePort: \"9786\"\nRateLimit: {DefaultCaller: {QPS: 5}}\n"), 0644)
+	restart, err := Reload()
+	if err != nil {
+		t.Fatalf("reload failed, err= %v", err)
+	}
+	if !reflect.DeepEqual(restart, []string{"ServicePort"}) {
+		t.Fatalf("restart= %v, want [ServicePort]", restart)
+	}
+	if ServiceConf.ServicePort != "9785" {
+		t.Fatalf("port= %v, want old port kept until restart", ServiceConf.ServicePort)
+	}
+	w, r := httptest.NewRecorder(), httptest.NewRequest("GET", "/config/restart", nil)
+	r.RemoteAddr = "127.0.0.1:12345"
+	AdminMux.ServeHTTP(w, r)
+	if body := w.Body.String(); !strings.Contains(body, `"restart_required":["ServicePort"]`) {
+		t.Fatalf("body= %v, want pending restart fields", body)
+	}
+	if GRateLimiter.callerLimit("guest").QPS != 5 {
+		t.Fatalf("rate limit not applied, limit= %+v", GRateLimiter.callerLimit("guest"))
+	}
+	if called != 1 {
+		t.Fatalf("called= %v, want 1", called)
+	}
+
+	// 校验失败时保留原配置
+	ioutil.WriteFile(file, []byte("ServicePort: \"9785\"\nRateLimit: {DefaultCaller: {QPS: 1}}\n"), 0644)
+	if _, err := Reload(); err == nil {
+		t.Fatalf("reload should fail without ServiceName")
+	}
+	if ServiceConf.ServiceName != "add" || ServiceConf.RateLimit.DefaultCaller.QPS != 5 {
+		t.Fatalf("conf= %+v, want old config kept", ServiceConf)
+	}
+	if called != 1 {
+		t.Fatalf("callback should not run on failed reload")
+	}
+}
+
+// 需要重启的配置项在发布前还原，helper中的默认consul配置保持不变
+func TestReloadKeepsConsul(t *testing.T) {
+	defer writeConfigFiles(t, map[string]string{
+		"service_info.yml": "ServiceName: add\nServicePort: \"9785\"\nConsul: {Address: \"127.0.0.1:8500\"}\n",
+	})()
+	if err := LoadServiceConfig(); err != nil {
+		t.Fatalf("load failed, err= %v", err)
+	}
+	file := filepath.Join(ConfigDir, ServiceConfigFile)
+	ioutil.WriteFile(file, []byte("ServiceName: add\nServicePort: \"9785\"\nConsul: {Address: \"10.0.0.1:8500\"}\n"), 0644)
+	restart, err := Reload()
+	if err != nil || !reflect.DeepEqual(restart, []string{"Consul"}) {
+		t.Fatalf("restart= %v, err= %v, want [Consul]", restart, err)
+	}
+	if addr := helper.DefaultConsulConfig().Address; addr != "127.0.0.1:8500" {
+		t.Fatalf("default consul address= %v, want old address kept until restart", addr)
+	}
+}
+
+// 还原后不再有需要重启的配置项，检测和还原使用同一张表
+func TestKeepRestartFields(t *testing.T) {
+	running := ServiceConfig{ServiceName: "add", ServicePort: "9785", AdvertiseInterface: "eth0", Checks: []CheckConfig{{Type: CheckGRPC}}}
+	conf := ServiceConfig{ServiceName: "sub", ServicePort: "9786", AdminPort: "9787", AdvertiseAddressEnv: "HOST_IP",
+		IP: helper.IPConfig{Family: helper.IPv6}, Tags: []string{"canary"}}
+	if restart := restartRequired(running, conf); len(restart) != 6 {
+		t.Fatalf("restart= %v, want 6 fields", restart)
+	}
+	keepRestartFields(&conf, running)
+	if restart := restartRequired(running, conf); len(restart) != 0 {
+		t.Fatalf("restart= %v after keeping running values", restart)
+	}
+	if len(conf.Tags) != 1 {
+		t.Fatalf("tags= %v, want reloadable field kept", conf.Tags)
+	}
+}
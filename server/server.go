@@ -2,7 +2,6 @@ package server
 
 import (
 	"code.byted.org/gopkg/pkg/log"
-	"fmt"
 	"github.com/Carey6918/PikaRPC/client"
 	"github.com/Carey6918/PikaRPC/helper"
 	"google.golang.org/grpc"
@@ -17,38 +16,57 @@ import (
 )
 
 type Server struct {
-	gServer  *grpc.Server
-	option   *Option
-	listener net.Listener
+	gServer   *grpc.Server
+	option    *Option
+	listener  net.Listener
+	advertise string        // 注册到consul的地址
+	port      int           // 实际监听的端口
+	done      chan struct{} // 停止服务时关闭，通知后台的上报等任务退出
 }
 
 var GServer *Server // 全局服务
 
 func Init() {
 	InitConfig()
+	InitLog()
+	InitDynamicConfig()
+	GFeatures.Start(ServiceConf.Features, nil)
 	resolver.Register(client.NewBuilder("test")) // consul lb
 
-	// 通过consul注册服务
-	if err := NewRegisterContest().Register(); err != nil {
-		log.Fatalf("consul register failed, err= %v", err)
-	}
-
-	NewServer(WithGRPCOpts(grpc.ConnectionTimeout(1 * time.Second)))
-	grpc_health_v1.RegisterHealthServer(GetGRPCServer(), &HealthServerImpl{})
+	GRateLimiter = NewRateLimiter(ServiceConf.RateLimit)
+	GConcurrencyLimiter = NewConcurrencyLimiter(ServiceConf.Concurrency)
+	NewServer(
+		WithGRPCOpts(grpc.ConnectionTimeout(1*time.Second)),
+		WithUnaryInterceptors(GRateLimiter.UnaryInterceptor, GConcurrencyLimiter.UnaryInterceptor),
+	)
+	grpc_health_v1.RegisterHealthServer(GetGRPCServer(), GHealthServer)
 }
 
 func NewServer(opts ...Options) {
 	var server Server
 	server.option = new(Option)
+	server.done = make(chan struct{})
 	for _, opt := range opts {
 		opt(server.option)
 	}
+	gOpts := server.option.gOpts
+	if len(server.option.interceptors) > 0 {
+		gOpts = append(gOpts, grpc.UnaryInterceptor(chainUnaryInterceptors(server.option.interceptors...)))
+	}
 	// 初始化gRPC服务
-	server.gServer = grpc.NewServer(server.option.gOpts...)
+	server.gServer = grpc.NewServer(gOpts...)
 	GServer = &server
 }
 
 func Run() error {
+	if ServiceConf.AdminPort != "" {
+		go serveAdmin()
+	}
+	if err := GServer.listen(); err != nil {
+		return err
+	}
+	// 开始监听、应用注册完gRPC服务后再注册到consul
+	startRegister()
 	errCh := make(chan error, 1)
 	go func() {
 		errCh <- GServer.serve()
@@ -68,23 +86,22 @@ func waitSignal(errCh chan error) error {
 			case syscall.SIGTERM: // 结束程序(可以被捕获、阻塞或忽略)
 				log.Infof("stop run, signals= %v",sig.String())
 				return nil
-			case syscall.SIGHUP, syscall.SIGINT: // 终端连接断开/用户发送(ctrl+c)结束
+			case syscall.SIGINT: // 用户发送(ctrl+c)结束
 				GServer.stop()
 				log.Infof("stop run, signals= %v",sig.String())
 				return nil
+			case syscall.SIGHUP: // 重新加载配置，失败时保留原配置继续运行
+				log.Infof("reload config, signals= %v", sig.String())
+				// 需要重启的配置项记录在PendingRestart中，可通过管理接口/config/restart查看
+				Reload()
 			}
 		case err := <-errCh:
 			return err
 		}
 	}
-	return <-errCh
 }
 
 func (s *Server) serve() error {
-	if err := s.listen(); err != nil {
-		return err
-	}
-
 	// 注册gRPC服务
 	reflection.Register(s.gServer)
 	if err := s.gServer.Serve(s.listener); err != nil {
@@ -94,16 +111,31 @@ func (s *Server) serve() error {
 }
 
 func (s *Server) stop() error {
+	GHealthServer.SetServingStatus("", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+	close(s.done)
 	return s.listener.Close()
 }
 
 func (s *Server) listen() error {
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%s", helper.GetLocalIP(), ServiceConf.ServicePort))
+	advertise, err := advertiseAddress()
+	if err != nil {
+		log.Errorf("resolve advertise address failed, err= %v", err)
+		return err
+	}
+	bind, err := bindAddress()
+	if err != nil {
+		log.Errorf("resolve bind address failed, err= %v", err)
+		return err
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(bind, ServiceConf.ServicePort))
 	if err != nil {
 		log.Errorf("listen tcp failed, err= %v", err)
 		return err
 	}
 	s.listener = listener
+	s.advertise = advertise
+	s.port = listener.Addr().(*net.TCPAddr).Port
+	log.Infof("listening on %v, advertise %v", listener.Addr(), net.JoinHostPort(s.advertise, helper.I2S(s.port)))
 	return nil
 }
 